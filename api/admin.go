@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"lunobot/logging"
+	"lunobot/models"
+	"lunobot/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRequestBodySize = 64 << 10
+
+type adminHandlerFunc func(w http.ResponseWriter, r *http.Request, user *models.User)
+
+// admin authenticates the bearer token of the request and lets it through
+// only if the token's user may use the bot and has the permission.
+func (s *Server) admin(permission models.Permission, handler adminHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lunobot"`)
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		apiToken, err := s.apiTokenService.Authenticate(token)
+		if err == models.ErrAPITokenNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lunobot", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error authenticating API token", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		user, err := s.userService.GetUserByTelegramID(apiToken.TelegramID)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Error getting user of API token",
+				"user_id", apiToken.TelegramID, "token_id", apiToken.ID, "error", err)
+			writeError(w, http.StatusForbidden, "token user not found")
+			return
+		}
+		if user.Banned || user.Membership != models.MembershipActive || !user.HasPermission(permission) {
+			writeError(w, http.StatusForbidden, "permission "+string(permission)+" required")
+			return
+		}
+
+		ctx := logging.With(r.Context(), slog.Int64("user_id", user.TelegramID), slog.Int64("token_id", apiToken.ID))
+		handler(w, r.WithContext(ctx), user)
+	}
+}
+
+// readJSON decodes the request body into v, rejecting unknown fields.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleSetStatus(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var request struct {
+		Open *bool `json:"open"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if request.Open == nil {
+		writeError(w, http.StatusBadRequest, "open is required")
+		return
+	}
+
+	if err := s.actions.SetOpenStatus(r.Context(), user, *request.Open); err != nil {
+		slog.ErrorContext(r.Context(), "Error setting status through API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]bool{"open": *request.Open})
+}
+
+func (s *Server) handleSetKeys(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var request struct {
+		Location string `json:"location"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if request.Location != "admin" && request.Location != "lobby" {
+		writeError(w, http.StatusBadRequest, `location must be "admin" or "lobby"`)
+		return
+	}
+
+	if err := s.actions.SetKeysLocation(r.Context(), user, request.Location == "admin"); err != nil {
+		slog.ErrorContext(r.Context(), "Error setting keys location through API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]string{"location": request.Location})
+}
+
+// handleListIdeas lists the active ideas, or every idea with ?all=true.
+func (s *Server) handleListIdeas(w http.ResponseWriter, r *http.Request, user *models.User) {
+	all, err := strconv.ParseBool(r.URL.Query().Get("all"))
+	if err != nil && r.URL.Query().Get("all") != "" {
+		writeError(w, http.StatusBadRequest, "all must be true or false")
+		return
+	}
+
+	var ideas []models.Idea
+	if all {
+		ideas, err = s.ideaService.GetAllIdeas()
+	} else {
+		ideas, err = s.ideaService.GetActiveIdeas()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting ideas for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if ideas == nil {
+		ideas = []models.Idea{}
+	}
+	writeJSON(w, r, map[string][]models.Idea{"ideas": ideas})
+}
+
+func (s *Server) handleDeleteIdea(w http.ResponseWriter, r *http.Request, user *models.User) {
+	ideaID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid idea ID")
+		return
+	}
+
+	err = s.actions.DeleteIdea(r.Context(), user, ideaID)
+	if err == models.ErrIdeaNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting idea through API", "idea_id", ideaID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListUsers returns a page of the user directory, optionally filtered
+// by role name and membership.
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request, user *models.User) {
+	page, ok := queryInt(r, "page", 1)
+	if !ok || page < 1 {
+		writeError(w, http.StatusBadRequest, "page must be a positive number")
+		return
+	}
+
+	var filter models.UserFilter
+	if name := r.URL.Query().Get("role"); name != "" {
+		role, err := s.roleService.GetRoleByName(name)
+		if err == models.ErrRoleNotFound {
+			writeError(w, http.StatusBadRequest, "unknown role "+strconv.Quote(name))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error getting role for API", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		filter.RoleID = role.ID
+	}
+	switch membership := models.Membership(r.URL.Query().Get("membership")); membership {
+	case "", models.MembershipActive, models.MembershipPending, models.MembershipRejected:
+		filter.Membership = membership
+	default:
+		writeError(w, http.StatusBadRequest, "unknown membership "+strconv.Quote(string(membership)))
+		return
+	}
+
+	users, totalPages, err := s.userService.GetUsers(filter, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting users for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if page > totalPages || users == nil {
+		// GetUsers clamps to the last page; past the end there is nothing.
+		users = []models.User{}
+	}
+	writeJSON(w, r, struct {
+		Page       int           `json:"page"`
+		TotalPages int           `json:"total_pages"`
+		Users      []models.User `json:"users"`
+	}{page, totalPages, users})
+}
+
+// handleSetRole changes a user's role. Taking away someone's ability to
+// manage rights must be confirmed, as it must in Telegram.
+func (s *Server) handleSetRole(w http.ResponseWriter, r *http.Request, user *models.User) {
+	targetID, err := strconv.ParseInt(r.PathValue("telegram_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid Telegram ID")
+		return
+	}
+	var request struct {
+		Role            string    `json:"role"`
+		ExpiresAt       time.Time `json:"expires_at"`
+		ConfirmDemotion bool      `json:"confirm_demotion"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if !request.ExpiresAt.IsZero() && !request.ExpiresAt.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	role, err := s.roleService.GetRoleByName(request.Role)
+	if err == models.ErrRoleNotFound {
+		writeError(w, http.StatusBadRequest, "unknown role "+strconv.Quote(request.Role))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting role for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	target, err := s.userService.GetUserByTelegramID(targetID)
+	if err == models.ErrUserNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting user for API", "target_id", targetID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if services.IsAdminDemotion(target, role) && !request.ConfirmDemotion {
+		writeError(w, http.StatusConflict, "this takes away the user's ability to manage rights; set confirm_demotion to proceed")
+		return
+	}
+
+	err = s.actions.ChangeRole(r.Context(), user, target, role, request.ExpiresAt)
+	if errors.Is(err, models.ErrLastAdmin) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error changing role through API", "target_id", targetID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	updated, err := s.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting user for API", "target_id", targetID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	sendJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var request struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	sent, err := s.actions.SendBroadcast(r.Context(), user, request.Text)
+	if err == models.ErrEmptyBroadcast || err == models.ErrBroadcastTooLong {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error sending broadcast through API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
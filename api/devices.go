@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"lunobot/logging"
+	"lunobot/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device events are signed with HMAC-SHA256 over "<timestamp>.<body>" using
+// the shared device secret. The timestamp is in Unix seconds and may have a
+// fraction, so that events sent within the same second differ. Requests older
+// than maxDeviceClockSkew are rejected so that a captured request cannot be
+// replayed later, and the signatures accepted within that window are
+// remembered so that it cannot be replayed sooner either.
+const (
+	deviceTimestampHeader = "X-Lunobot-Timestamp"
+	deviceSignatureHeader = "X-Lunobot-Signature"
+	maxDeviceClockSkew    = 5 * time.Minute
+)
+
+var deviceNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// deviceEvents maps each event a device may send to the change it makes.
+var deviceEvents = map[string]struct {
+	keys  bool
+	value bool
+}{
+	"unlocked":      {keys: false, value: true},
+	"locked":        {keys: false, value: false},
+	"keys_taken":    {keys: true, value: true},
+	"keys_returned": {keys: true, value: false},
+}
+
+// EnableDeviceEvents accepts door and lock events at /api/devices/events.
+// A change is applied once its events have settled for debounce, so a door
+// that flaps only changes the status once. It must be called before Start.
+func (s *Server) EnableDeviceEvents(secret string, debounce time.Duration) {
+	s.deviceSecret = []byte(secret)
+	s.deviceDebounce = debounce
+	s.devicePending = make(map[bool]*pendingDeviceChange)
+	s.deviceSeen = make(map[string]time.Time)
+	s.mux.HandleFunc("POST /api/devices/events", s.handleDeviceEvent)
+}
+
+// pendingDeviceChange is the latest value reported for the status (keys
+// false) or the keys (keys true), waiting for the debounce timer.
+type pendingDeviceChange struct {
+	device string
+	value  bool
+	timer  *time.Timer
+}
+
+func (s *Server) handleDeviceEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !s.validDeviceSignature(r, body) {
+		writeError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	if !s.firstDeviceSignature(strings.ToLower(r.Header.Get(deviceSignatureHeader))) {
+		writeError(w, http.StatusConflict, "request already received")
+		return
+	}
+
+	var event struct {
+		Device string `json:"device"`
+		Event  string `json:"event"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if !deviceNamePattern.MatchString(event.Device) {
+		writeError(w, http.StatusBadRequest, "device must be 1-32 lowercase letters, digits, _ or -")
+		return
+	}
+	change, ok := deviceEvents[event.Event]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unknown event "+strconv.Quote(event.Event))
+		return
+	}
+
+	ctx := logging.With(context.WithoutCancel(r.Context()), slog.String("device", event.Device))
+	slog.InfoContext(ctx, "Device reported an event", "event", event.Event)
+	s.scheduleDeviceChange(ctx, event.Device, change.keys, change.value)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) validDeviceSignature(r *http.Request, body []byte) bool {
+	timestamp := r.Header.Get(deviceTimestampHeader)
+	unix, err := strconv.ParseFloat(timestamp, 64)
+	if err != nil || strings.ContainsAny(timestamp, "eEinIN+-") {
+		return false
+	}
+	if skew := time.Since(time.Unix(0, int64(unix*1e9))); skew > maxDeviceClockSkew || skew < -maxDeviceClockSkew {
+		return false
+	}
+
+	signature, ok := strings.CutPrefix(r.Header.Get(deviceSignatureHeader), "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, s.deviceSecret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// firstDeviceSignature reports whether signature has not been accepted before,
+// and remembers it for as long as its timestamp is accepted. Signatures that
+// can no longer be accepted anyway are forgotten.
+func (s *Server) firstDeviceSignature(signature string) bool {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	now := time.Now()
+	for seen, expires := range s.deviceSeen {
+		if now.After(expires) {
+			delete(s.deviceSeen, seen)
+		}
+	}
+	if _, seen := s.deviceSeen[signature]; seen {
+		return false
+	}
+	// A timestamp up to maxDeviceClockSkew ahead stays valid for twice as long.
+	s.deviceSeen[signature] = now.Add(2 * maxDeviceClockSkew)
+	return true
+}
+
+// scheduleDeviceChange (re)starts the debounce timer for the status or the
+// keys with the latest reported value.
+func (s *Server) scheduleDeviceChange(ctx context.Context, device string, keys, value bool) {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	if pending := s.devicePending[keys]; pending != nil {
+		pending.timer.Stop()
+	}
+	pending := &pendingDeviceChange{device: device, value: value}
+	pending.timer = time.AfterFunc(s.deviceDebounce, func() {
+		s.deviceMu.Lock()
+		if s.devicePending[keys] != pending {
+			s.deviceMu.Unlock()
+			return
+		}
+		delete(s.devicePending, keys)
+		s.deviceApplying.Add(1)
+		s.deviceMu.Unlock()
+
+		defer s.deviceApplying.Done()
+		s.applyDeviceChange(ctx, pending.device, keys, pending.value)
+	})
+	s.devicePending[keys] = pending
+}
+
+// flushDeviceChanges applies the changes still waiting for their debounce
+// timer right away and waits for those already being applied, so that none
+// is lost when the bot stops.
+func (s *Server) flushDeviceChanges() {
+	s.deviceMu.Lock()
+	var pending []*pendingDeviceChange
+	var keys []bool
+	for k, change := range s.devicePending {
+		change.timer.Stop()
+		pending = append(pending, change)
+		keys = append(keys, k)
+	}
+	clear(s.devicePending)
+	s.deviceMu.Unlock()
+
+	ctx := logging.NewCorrelation(context.Background())
+	for i, change := range pending {
+		s.applyDeviceChange(ctx, change.device, keys[i], change.value)
+	}
+	s.deviceApplying.Wait()
+}
+
+// applyDeviceChange makes the change as the device, unless the status is
+// already what the device reports.
+func (s *Server) applyDeviceChange(ctx context.Context, device string, keys, value bool) {
+	status, err := s.statusService.GetStatus()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting status for device event", "error", err)
+		return
+	}
+
+	actor := &models.User{FirstName: "device:" + device}
+	if keys {
+		if status.TechnicalStatus == value {
+			return
+		}
+		err = s.actions.SetKeysLocation(ctx, actor, value)
+	} else {
+		if status.IsOpen == value {
+			return
+		}
+		err = s.actions.SetOpenStatus(ctx, actor, value)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error applying device event", "error", err)
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"lunobot/database"
+	"lunobot/models"
+	"lunobot/services"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDeviceSecret = "0123456789abcdef"
+
+// recordingActions records the status changes the API makes.
+type recordingActions struct {
+	mu     sync.Mutex
+	opened []bool
+	keys   []bool
+}
+
+func (a *recordingActions) SetOpenStatus(ctx context.Context, user *models.User, isOpen bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.opened = append(a.opened, isOpen)
+	return nil
+}
+
+func (a *recordingActions) SetKeysLocation(ctx context.Context, user *models.User, atAdmin bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = append(a.keys, atAdmin)
+	return nil
+}
+
+func (a *recordingActions) DeleteIdea(ctx context.Context, user *models.User, ideaID int64) error {
+	return nil
+}
+
+func (a *recordingActions) ChangeRole(ctx context.Context, user, target *models.User, role *models.Role, expiresAt time.Time) error {
+	return nil
+}
+
+func (a *recordingActions) SendBroadcast(ctx context.Context, user *models.User, text string) (int, error) {
+	return 0, nil
+}
+
+func (a *recordingActions) openCalls() []bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]bool(nil), a.opened...)
+}
+
+// newDeviceTestServer serves device events that settle after debounce.
+func newDeviceTestServer(t *testing.T, debounce time.Duration) (*httptest.Server, *Server, *recordingActions) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	actions := &recordingActions{}
+	s := NewServer(services.NewStatusService(db), nil, nil, nil, nil, nil, nil, actions, nil, time.UTC)
+	s.EnableDeviceEvents(testDeviceSecret, debounce)
+	server := httptest.NewServer(withCorrelation(s.mux))
+	t.Cleanup(server.Close)
+	return server, s, actions
+}
+
+func (s *Server) pendingDeviceChanges() int {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+	return len(s.devicePending)
+}
+
+func sign(timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(testDeviceSecret))
+	mac.Write([]byte(timestamp + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postDeviceEvent(t *testing.T, server *httptest.Server, body string, headers map[string]string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/devices/events", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// signedHeaders signs body with a timestamp precise enough that no two
+// requests share it.
+func signedHeaders(body string) map[string]string {
+	now := time.Now()
+	timestamp := fmt.Sprintf("%d.%09d", now.Unix(), now.Nanosecond())
+	return map[string]string{
+		deviceTimestampHeader: timestamp,
+		deviceSignatureHeader: sign(timestamp, body),
+	}
+}
+
+func postSignedEvent(t *testing.T, server *httptest.Server, event string) int {
+	t.Helper()
+	body := `{"device":"front-door","event":"` + event + `"}`
+	return postDeviceEvent(t, server, body, signedHeaders(body))
+}
+
+func TestDeviceEventSignature(t *testing.T) {
+	server, s, _ := newDeviceTestServer(t, time.Hour)
+	body := `{"device":"front-door","event":"unlocked"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-maxDeviceClockSkew-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(maxDeviceClockSkew+time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"missing", nil},
+		{"missing signature", map[string]string{deviceTimestampHeader: now}},
+		{"missing timestamp", map[string]string{deviceSignatureHeader: sign(now, body)}},
+		{"bad signature", map[string]string{deviceTimestampHeader: now, deviceSignatureHeader: sign(now, body+" ")}},
+		{"wrong secret", map[string]string{deviceTimestampHeader: now, deviceSignatureHeader: "sha256=" + strings.Repeat("0", 64)}},
+		{"no sha256 prefix", map[string]string{deviceTimestampHeader: now, deviceSignatureHeader: strings.TrimPrefix(sign(now, body), "sha256=")}},
+		{"stale", map[string]string{deviceTimestampHeader: stale, deviceSignatureHeader: sign(stale, body)}},
+		{"future", map[string]string{deviceTimestampHeader: future, deviceSignatureHeader: sign(future, body)}},
+		{"not a number", map[string]string{deviceTimestampHeader: "NaN", deviceSignatureHeader: sign("NaN", body)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := postDeviceEvent(t, server, body, tt.headers); code != http.StatusUnauthorized {
+				t.Errorf("got %d, want 401", code)
+			}
+		})
+	}
+
+	if n := s.pendingDeviceChanges(); n != 0 {
+		t.Errorf("rejected events scheduled %d changes", n)
+	}
+}
+
+func TestDeviceEventRejectsReplays(t *testing.T) {
+	server, _, _ := newDeviceTestServer(t, time.Hour)
+	body := `{"device":"front-door","event":"unlocked"}`
+	headers := signedHeaders(body)
+	if code := postDeviceEvent(t, server, body, headers); code != http.StatusAccepted {
+		t.Fatalf("first request: got %d, want 202", code)
+	}
+	if code := postDeviceEvent(t, server, body, headers); code != http.StatusConflict {
+		t.Errorf("replayed request: got %d, want 409", code)
+	}
+
+	// The signature is hex, so its case must not make a replay look new.
+	headers[deviceSignatureHeader] = "sha256=" + strings.ToUpper(strings.TrimPrefix(headers[deviceSignatureHeader], "sha256="))
+	if code := postDeviceEvent(t, server, body, headers); code != http.StatusConflict {
+		t.Errorf("replayed request in upper case: got %d, want 409", code)
+	}
+
+	// Whole seconds are still accepted.
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if code := postDeviceEvent(t, server, body, map[string]string{
+		deviceTimestampHeader: timestamp,
+		deviceSignatureHeader: sign(timestamp, body),
+	}); code != http.StatusAccepted {
+		t.Errorf("request with whole seconds: got %d, want 202", code)
+	}
+}
+
+func TestDeviceEventRejectsUnknownEvents(t *testing.T) {
+	server, _, _ := newDeviceTestServer(t, time.Hour)
+	for _, event := range []string{"opened", "UNLOCKED", ""} {
+		if code := postSignedEvent(t, server, event); code != http.StatusBadRequest {
+			t.Errorf("event %q: got %d, want 400", event, code)
+		}
+	}
+}
+
+func TestDeviceEventDebounce(t *testing.T) {
+	// A new database starts open, so the flapping door ends up closing it.
+	// The debounce never runs out here; flushing settles the events instead.
+	server, s, actions := newDeviceTestServer(t, time.Hour)
+	for _, event := range []string{"locked", "unlocked", "locked", "unlocked", "locked"} {
+		if code := postSignedEvent(t, server, event); code != http.StatusAccepted {
+			t.Fatalf("event %q: got %d, want 202", event, code)
+		}
+	}
+
+	if calls := actions.openCalls(); len(calls) != 0 {
+		t.Errorf("status changed before the events settled: %v", calls)
+	}
+	if n := s.pendingDeviceChanges(); n != 1 {
+		t.Errorf("got %d pending changes, want 1", n)
+	}
+	s.flushDeviceChanges()
+	if calls := actions.openCalls(); len(calls) != 1 || calls[0] {
+		t.Errorf("SetOpenStatus calls = %v, want a single close", calls)
+	}
+}
+
+func TestDeviceEventAppliedAfterDebounce(t *testing.T) {
+	server, _, actions := newDeviceTestServer(t, time.Millisecond)
+	if code := postSignedEvent(t, server, "locked"); code != http.StatusAccepted {
+		t.Fatalf("got %d, want 202", code)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(actions.openCalls()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls := actions.openCalls(); len(calls) != 1 || calls[0] {
+		t.Errorf("SetOpenStatus calls = %v, want a single close", calls)
+	}
+}
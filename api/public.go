@@ -0,0 +1,141 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type statusResponse struct {
+	Open      bool      `json:"open"`
+	Keys      string    `json:"keys"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type hoursResponse struct {
+	Timezone  string            `json:"timezone,omitempty"`
+	UTCOffset string            `json:"utc_offset"`
+	AutoClose autoCloseResponse `json:"auto_close"`
+}
+
+type autoCloseResponse struct {
+	Enabled   bool   `json:"enabled"`
+	CloseTime string `json:"close_time"`
+}
+
+type historyResponse struct {
+	Month      int            `json:"month"`
+	Year       int            `json:"year"`
+	Page       int            `json:"page"`
+	TotalPages int            `json:"total_pages"`
+	Events     []historyEvent `json:"events"`
+}
+
+// historyEvent is a status log entry without the name of whoever made the
+// change, which is not for public eyes.
+type historyEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Value string    `json:"value"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.statusService.GetStatus()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting status for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	keys := "lobby"
+	if status.TechnicalStatus {
+		keys = "admin"
+	}
+	writeJSON(w, r, statusResponse{
+		Open:      status.IsOpen,
+		Keys:      keys,
+		UpdatedAt: status.UpdatedAt,
+	})
+}
+
+func (s *Server) handleHours(w http.ResponseWriter, r *http.Request) {
+	settings, err := s.schedulerService.GetSettings()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting auto-close settings for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	// The system time zone is only named when the timezone setting is used.
+	timezone := s.location.String()
+	if timezone == "Local" {
+		timezone = ""
+	}
+	writeJSON(w, r, hoursResponse{
+		Timezone:  timezone,
+		UTCOffset: time.Now().In(s.location).Format("-07:00"),
+		AutoClose: autoCloseResponse{
+			Enabled:   settings.Enabled,
+			CloseTime: settings.CloseTime,
+		},
+	})
+}
+
+// handleHistory lists one page of a month's status and keys changes, newest
+// first. The month, year and page query parameters default to the current
+// month and the first page.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(s.location)
+	month, ok := queryInt(r, "month", int(now.Month()))
+	if !ok || month < 1 || month > 12 {
+		writeError(w, http.StatusBadRequest, "month must be between 1 and 12")
+		return
+	}
+	year, ok := queryInt(r, "year", now.Year())
+	if !ok || year < 2000 || year > 9999 {
+		writeError(w, http.StatusBadRequest, "invalid year")
+		return
+	}
+	page, ok := queryInt(r, "page", 1)
+	if !ok || page < 1 {
+		writeError(w, http.StatusBadRequest, "page must be a positive number")
+		return
+	}
+
+	entries, totalPages, err := s.logService.GetLogEntries(month, year, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting status log for API", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if page > totalPages {
+		// GetLogEntries clamps to the last page; past the end there is nothing.
+		entries = nil
+	}
+
+	events := make([]historyEvent, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, historyEvent{
+			Time:  entry.Timestamp,
+			Event: entry.Action,
+			Value: entry.ActionData,
+		})
+	}
+	writeJSON(w, r, historyResponse{
+		Month:      month,
+		Year:       year,
+		Page:       page,
+		TotalPages: totalPages,
+		Events:     events,
+	})
+}
+
+func queryInt(r *http.Request, key string, fallback int) (int, bool) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
@@ -0,0 +1,231 @@
+// Package api serves the bot's data over HTTP for the website and the screen
+// at the entrance.
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"lunobot/health"
+	"lunobot/logging"
+	"lunobot/models"
+	"lunobot/services"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions performs changes the same way the Telegram handlers do, so that
+// changes made through the API are validated, audited and announced alike.
+type Actions interface {
+	SetOpenStatus(ctx context.Context, user *models.User, isOpen bool) error
+	SetKeysLocation(ctx context.Context, user *models.User, atAdmin bool) error
+	DeleteIdea(ctx context.Context, user *models.User, ideaID int64) error
+	ChangeRole(ctx context.Context, user, target *models.User, role *models.Role, expiresAt time.Time) error
+	SendBroadcast(ctx context.Context, user *models.User, text string) (int, error)
+}
+
+type Server struct {
+	statusService    *services.StatusService
+	schedulerService *services.SchedulerService
+	logService       *services.LogService
+	userService      *services.UserService
+	ideaService      *services.IdeaService
+	roleService      *services.RoleService
+	apiTokenService  *services.APITokenService
+	actions          Actions
+	corsOrigins      []string
+	location         *time.Location
+	mux              *http.ServeMux
+
+	deviceSecret   []byte
+	deviceDebounce time.Duration
+	deviceMu       sync.Mutex
+	devicePending  map[bool]*pendingDeviceChange
+	deviceSeen     map[string]time.Time
+	deviceApplying sync.WaitGroup
+}
+
+// NewServer creates the API server. Browsers may call the public endpoints
+// from corsOrigins; "*" allows any origin. Opening hours and the history are
+// given in location.
+func NewServer(
+	statusService *services.StatusService,
+	schedulerService *services.SchedulerService,
+	logService *services.LogService,
+	userService *services.UserService,
+	ideaService *services.IdeaService,
+	roleService *services.RoleService,
+	apiTokenService *services.APITokenService,
+	actions Actions,
+	corsOrigins []string,
+	location *time.Location,
+) *Server {
+	s := &Server{
+		statusService:    statusService,
+		schedulerService: schedulerService,
+		logService:       logService,
+		userService:      userService,
+		ideaService:      ideaService,
+		roleService:      roleService,
+		apiTokenService:  apiTokenService,
+		actions:          actions,
+		corsOrigins:      corsOrigins,
+		location:         location,
+		mux:              http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/api/status", s.public(s.handleStatus))
+	s.mux.HandleFunc("/api/hours", s.public(s.handleHours))
+	s.mux.HandleFunc("/api/history", s.public(s.handleHistory))
+
+	s.mux.HandleFunc("PUT /api/admin/status", s.admin(models.PermStatusChange, s.handleSetStatus))
+	s.mux.HandleFunc("PUT /api/admin/keys", s.admin(models.PermKeysChange, s.handleSetKeys))
+	s.mux.HandleFunc("GET /api/admin/ideas", s.admin(models.PermIdeasRead, s.handleListIdeas))
+	s.mux.HandleFunc("DELETE /api/admin/ideas/{id}", s.admin(models.PermIdeasManage, s.handleDeleteIdea))
+	s.mux.HandleFunc("GET /api/admin/users", s.admin(models.PermUsersManage, s.handleListUsers))
+	s.mux.HandleFunc("PUT /api/admin/users/{telegram_id}/role", s.admin(models.PermRightsManage, s.handleSetRole))
+	s.mux.HandleFunc("POST /api/admin/broadcasts", s.admin(models.PermBroadcastSend, s.handleBroadcast))
+	return s
+}
+
+// EnableProbes serves the /healthz and /readyz probes of checker alongside
+// the API. It must be called before Start.
+func (s *Server) EnableProbes(checker *health.Checker) {
+	checker.Register(s.mux)
+}
+
+// Start serves the API on listen until ctx is done. It returns once the
+// requests in flight are answered, or shutdownCtx is done, and pending device
+// changes are applied.
+func (s *Server) Start(ctx, shutdownCtx context.Context, listen string) error {
+	server := &http.Server{Addr: listen, Handler: withCorrelation(s.mux), ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error stopping API server", "error", err)
+		}
+	}()
+
+	slog.Info("Serving the HTTP API", "listen", listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	s.flushDeviceChanges()
+	return nil
+}
+
+// withCorrelation gives each request a correlation ID, returned in the
+// X-Request-ID header, and logs the request under it.
+func withCorrelation(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.NewCorrelationID()
+		ctx := logging.With(r.Context(), slog.String("correlation_id", id),
+			slog.String("route", r.Method+" "+r.URL.Path))
+		w.Header().Set("X-Request-ID", id)
+		slog.DebugContext(ctx, "Handling API request")
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// public wraps a read-only endpoint with CORS headers and answers preflight
+// requests. Unless any origin is allowed, the headers depend on the Origin of
+// the request, so every response says so to caches.
+func (s *Server) public(handler http.HandlerFunc) http.HandlerFunc {
+	anyOrigin := slices.Contains(s.corsOrigins, "*")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.corsOrigins) > 0 && !anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && s.allowOrigin(origin) {
+			if anyOrigin {
+				origin = "*"
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			handler(w, r)
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+func (s *Server) allowOrigin(origin string) bool {
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON sends v with an ETag of its content, or 304 Not Modified if the
+// client already has it.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding API response", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(append(body, '\n'))
+	}
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendJSON sends v without caching headers, for errors and changes.
+func sendJSON(w http.ResponseWriter, code int, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		slog.Error("Error encoding API response", "error", err)
+		code = http.StatusInternalServerError
+		body.Reset()
+		body.WriteString(`{"error":"internal error"}` + "\n")
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(body.Bytes())
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	sendJSON(w, code, map[string]string{"error": message})
+}
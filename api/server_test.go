@@ -0,0 +1,51 @@
+package api
+
+import (
+	"lunobot/database"
+	"lunobot/services"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublicCORSHeaders(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tests := []struct {
+		name        string
+		corsOrigins []string
+		origin      string
+		allowOrigin string
+		vary        string
+	}{
+		{"allowed origin", []string{"https://example.com"}, "https://example.com", "https://example.com", "Origin"},
+		{"other origin", []string{"https://example.com"}, "https://evil.example", "", "Origin"},
+		{"no origin", []string{"https://example.com"}, "", "", "Origin"},
+		{"any origin", []string{"*"}, "https://example.com", "*", ""},
+		{"no cors", nil, "https://example.com", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(services.NewStatusService(db), nil, nil, nil, nil, nil, nil, nil, tt.corsOrigins, time.UTC)
+			req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rec.Header().Get("Vary"); got != tt.vary {
+				t.Errorf("Vary = %q, want %q", got, tt.vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"lunobot/models"
+	"lunobot/services"
+	"strconv"
+)
+
+// bootstrapAdmins gives the admin role to the Telegram IDs listed in the
+// configuration and logs any difference between the configuration and the
+// database. Admins missing from the configuration are reported but kept.
+func bootstrapAdmins(ctx context.Context, adminIDs []int64, userService *services.UserService, roleService *services.RoleService, auditService *services.AuditService) error {
+	if len(adminIDs) == 0 {
+		return nil
+	}
+
+	adminRole, err := roleService.GetRoleByName(models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	configured := make(map[int64]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		configured[id] = true
+
+		previous, err := userService.GetUserByTelegramID(id)
+		if err == nil && previous.RoleID == adminRole.ID {
+			continue
+		}
+		if err != nil && err != models.ErrUserNotFound {
+			return err
+		}
+
+		previous, err = userService.GrantRole(ctx, id, adminRole)
+		if err != nil {
+			return err
+		}
+		before := ""
+		if previous == nil {
+			slog.InfoContext(ctx, "Bootstrap: created admin from ADMIN_IDS", "user_id", id)
+		} else {
+			before = roleName(previous, roleService)
+			slog.WarnContext(ctx, "Bootstrap: drift, restored admin from ADMIN_IDS", "user_id", id, "role", before)
+		}
+		if err := auditService.Record(ctx, &models.User{FirstName: "config"}, models.AuditRightsChange, userLabel(previous, id), before, adminRole.Name); err != nil {
+			slog.ErrorContext(ctx, "Bootstrap: failed to record audit entry", "user_id", id, "error", err)
+		}
+	}
+
+	admins, err := userService.GetUsersWithRole(adminRole.ID)
+	if err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if !configured[admin.TelegramID] {
+			slog.WarnContext(ctx, "Bootstrap: drift, user has the admin role but is not listed in ADMIN_IDS",
+				"user_id", admin.TelegramID, "name", admin.GetDisplayName())
+		}
+	}
+	return nil
+}
+
+func roleName(user *models.User, roleService *services.RoleService) string {
+	if role, err := roleService.GetRoleByID(user.RoleID); err == nil {
+		return role.Name
+	}
+	return models.BuiltInRoleForRights(user.Rights)
+}
+
+func userLabel(user *models.User, telegramID int64) string {
+	if user != nil && user.GetDisplayName() != "" {
+		return user.GetDisplayName()
+	}
+	return strconv.FormatInt(telegramID, 10)
+}
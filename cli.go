@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/config"
+	"lunobot/database"
+	"lunobot/logging"
+	"lunobot/models"
+	"lunobot/services"
+	"os"
+	"strconv"
+)
+
+const usage = `Usage:
+  lunobot                             run the bot
+  lunobot grant <telegram_id> <role>  give a user a role, e.g. to recover admin access
+  lunobot config check [file]         validate the configuration file and env vars
+  lunobot token create <telegram_id> <name>
+                                      issue an admin API token acting as the user
+  lunobot token list                  list admin API tokens
+  lunobot token revoke <id>           revoke an admin API token`
+
+// runCommand runs a command-line subcommand and returns the process exit code.
+func runCommand(args []string) int {
+	// Commands report through their own output; only problems are logged.
+	if err := logging.Setup(os.Stderr, slog.LevelWarn, logging.FormatText); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx := logging.NewCorrelation(context.Background())
+
+	switch args[0] {
+	case "grant":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			return 1
+		}
+		return runGrant(ctx, cfg, args[1], args[2])
+	case "config":
+		if len(args) < 2 || len(args) > 3 || args[1] != "check" {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		path := config.Path()
+		if len(args) == 3 {
+			path = args[2]
+		}
+		return runConfigCheck(path)
+	case "token":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		return runToken(ctx, args[1], args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+}
+
+func runGrant(ctx context.Context, cfg *config.Config, idArg, roleArg string) int {
+	telegramID, err := strconv.ParseInt(idArg, 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid Telegram ID %q\n", idArg)
+		return 2
+	}
+
+	db, err := database.NewDB(cfg.DatabasePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	userService := services.NewUserService(db, cfg.UsersPerPage)
+	roleService := services.NewRoleService(db)
+	auditService := services.NewAuditService(db, cfg.EntriesPerPage)
+
+	role, err := roleService.GetRoleByName(roleArg)
+	if err == models.ErrRoleNotFound {
+		fmt.Fprintf(os.Stderr, "Role %q not found. Available roles:\n", roleArg)
+		if roles, err := roleService.GetRoles(); err == nil {
+			for _, r := range roles {
+				fmt.Fprintf(os.Stderr, "  %s\n", r.Name)
+			}
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get role: %v\n", err)
+		return 1
+	}
+
+	previous, err := userService.GrantRole(ctx, telegramID, role)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to grant role: %v\n", err)
+		return 1
+	}
+
+	before := ""
+	if previous != nil {
+		before = roleName(previous, roleService)
+	}
+	if err := auditService.Record(ctx, &models.User{FirstName: "cli"}, models.AuditRightsChange, userLabel(previous, telegramID), before, role.Name); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record audit entry: %v\n", err)
+	}
+
+	fmt.Printf("Granted role %s to %s\n", role.Name, userLabel(previous, telegramID))
+	return 0
+}
+
+func runConfigCheck(path string) int {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Configuration is valid: transport %s, database %s, time zone %s, %d admin ID(s)\n",
+		cfg.Transport, cfg.DatabasePath, cfg.Location, len(cfg.AdminIDs))
+	return 0
+}
+
+func runToken(ctx context.Context, command string, args []string) int {
+	switch {
+	case command == "create" && len(args) == 2:
+	case command == "list" && len(args) == 0:
+	case command == "revoke" && len(args) == 1:
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	db, err := database.NewDB(cfg.DatabasePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	apiTokenService := services.NewAPITokenService(db)
+	auditService := services.NewAuditService(db, cfg.EntriesPerPage)
+	cli := &models.User{FirstName: "cli"}
+
+	switch command {
+	case "create":
+		telegramID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid Telegram ID %q\n", args[0])
+			return 2
+		}
+		user, err := services.NewUserService(db, cfg.UsersPerPage).GetUserByTelegramID(telegramID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get user %d: %v\n", telegramID, err)
+			return 1
+		}
+
+		token, apiToken, err := apiTokenService.CreateToken(telegramID, args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create token: %v\n", err)
+			return 1
+		}
+		if err := auditService.Record(ctx, cli, models.AuditTokenCreate, user.GetDisplayName(), "", apiToken.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record audit entry: %v\n", err)
+		}
+		fmt.Printf("Created token #%d %q acting as %s. Store it now, it is not shown again:\n%s\n",
+			apiToken.ID, apiToken.Name, user.GetDisplayName(), token)
+	case "list":
+		tokens, err := apiTokenService.GetTokens()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list tokens: %v\n", err)
+			return 1
+		}
+		if len(tokens) == 0 {
+			fmt.Println("No tokens")
+		}
+		for _, token := range tokens {
+			lastUsed := "never used"
+			if !token.LastUsedAt.IsZero() {
+				lastUsed = "last used " + token.LastUsedAt.In(cfg.Location).Format("02.01.2006 15:04")
+			}
+			fmt.Printf("#%d  %s  user %d  created %s  %s\n",
+				token.ID, token.Name, token.TelegramID, token.CreatedAt.In(cfg.Location).Format("02.01.2006 15:04"), lastUsed)
+		}
+	case "revoke":
+		tokenID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid token ID %q\n", args[0])
+			return 2
+		}
+		if err := apiTokenService.DeleteToken(tokenID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke token #%d: %v\n", tokenID, err)
+			return 1
+		}
+		if err := auditService.Record(ctx, cli, models.AuditTokenRevoke, fmt.Sprintf("token #%d", tokenID), "", ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record audit entry: %v\n", err)
+		}
+		fmt.Printf("Revoked token #%d\n", tokenID)
+	}
+	return 0
+}
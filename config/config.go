@@ -1,38 +1,355 @@
 package config
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"lunobot/logging"
+	"lunobot/models"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the configuration file read when CONFIG_PATH is not set.
+// It is optional: without it the bot is configured from env vars alone.
+const DefaultPath = "config.yaml"
+
+const webhookSecretChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-"
+
+const (
+	TransportPolling = "polling"
+	TransportWebhook = "webhook"
 )
 
+// Config holds every tunable of the bot. Each setting can be given in the
+// configuration file under its yaml key and overridden by the env var of the
+// same name in upper case, e.g. ideas_per_hour and IDEAS_PER_HOUR. Lists are
+// comma-separated in env vars.
 type Config struct {
-	TelegramToken string
-	DatabasePath  string
-	Debug         bool
+	TelegramToken    string        `yaml:"telegram_bot_token"`
+	Transport        string        `yaml:"transport"`
+	WebhookURL       string        `yaml:"webhook_url"`
+	WebhookListen    string        `yaml:"webhook_listen"`
+	WebhookSecret    string        `yaml:"webhook_secret"`
+	DatabasePath     string        `yaml:"database_path"`
+	LogDir           string        `yaml:"log_dir"`
+	Timezone         string        `yaml:"timezone"`
+	LogLevel         string        `yaml:"log_level"`
+	LogFormat        string        `yaml:"log_format"`
+	Debug            bool          `yaml:"debug"`
+	AdminIDs         []int64       `yaml:"admin_ids"`
+	ClosedMembership bool          `yaml:"closed_membership"`
+	IdeasPerHour     int           `yaml:"ideas_per_hour"`
+	IdeasPerDay      int           `yaml:"ideas_per_day"`
+	IdeasBannedWords []string      `yaml:"ideas_banned_words"`
+	IdeaDigestTime   string        `yaml:"idea_digest_time"`
+	StateTTL         time.Duration `yaml:"state_ttl"`
+	SchedulerTick    time.Duration `yaml:"scheduler_tick"`
+	UpdateWorkers    int           `yaml:"update_workers"`
+	UpdateQueueSize  int           `yaml:"update_queue_size"`
+	EntriesPerPage   int           `yaml:"entries_per_page"`
+	UsersPerPage     int           `yaml:"users_per_page"`
+	APIListen        string        `yaml:"api_listen"`
+	APICORSOrigins   []string      `yaml:"api_cors_origins"`
+	DeviceSecret     string        `yaml:"device_secret"`
+	DeviceDebounce   time.Duration `yaml:"device_debounce"`
+	MetricsListen    string        `yaml:"metrics_listen"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"`
+
+	OutgoingWebhooks []OutgoingWebhook `yaml:"outgoing_webhooks"`
+
+	// Location is the parsed Timezone.
+	Location *time.Location `yaml:"-"`
+	// Level is the parsed LogLevel.
+	Level slog.Level `yaml:"-"`
 }
 
-func Load() *Config {
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN environment variable is required")
+// OutgoingWebhook is a URL notified of the listed events, or of every event
+// if none are listed. In the env var, the whole list is given as JSON.
+type OutgoingWebhook struct {
+	URL    string   `yaml:"url" json:"url"`
+	Secret string   `yaml:"secret" json:"secret"`
+	Events []string `yaml:"events" json:"events"`
+}
+
+func defaults() *Config {
+	return &Config{
+		Transport:       TransportPolling,
+		WebhookListen:   ":8443",
+		DatabasePath:    "bot.db",
+		LogDir:          "log",
+		LogLevel:        "info",
+		LogFormat:       logging.FormatText,
+		IdeasPerHour:    5,
+		IdeasPerDay:     20,
+		IdeaDigestTime:  "09:00",
+		StateTTL:        10 * time.Minute,
+		SchedulerTick:   30 * time.Second,
+		UpdateWorkers:   8,
+		UpdateQueueSize: 64,
+		EntriesPerPage:  5,
+		UsersPerPage:    8,
+		DeviceDebounce:  30 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
 	}
+}
 
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath = "bot.db"
+// Path returns the configuration file named by CONFIG_PATH, or DefaultPath.
+func Path() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
 	}
+	return DefaultPath
+}
+
+// Load reads the configuration from Path and the environment and validates it.
+func Load() (*Config, error) {
+	return LoadFile(Path())
+}
+
+// LoadFile reads the configuration file at path, applies env var overrides
+// and validates the result. A missing file is only an error if it is not
+// DefaultPath. All problems found are reported together.
+func LoadFile(path string) (*Config, error) {
+	cfg := defaults()
 
-	debug := false
-	if debugStr := os.Getenv("DEBUG"); debugStr != "" {
-		if d, err := strconv.ParseBool(debugStr); err == nil {
-			debug = d
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
 		}
+	case os.IsNotExist(err) && path == DefaultPath:
+	default:
+		return nil, err
 	}
 
-	return &Config{
-		TelegramToken: token,
-		DatabasePath:  dbPath,
-		Debug:         debug,
+	var problems []error
+	problems = append(problems, cfg.applyEnv()...)
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides every setting whose env var is set.
+func (c *Config) applyEnv() []error {
+	var problems []error
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := field.Tag.Get("yaml")
+		if key == "" || key == "-" {
+			continue
+		}
+		env := strings.ToUpper(key)
+		raw, ok := os.LookupEnv(env)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setField(value.Field(i), raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", env, err))
+		}
+	}
+	return problems
+}
+
+func setField(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		field.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 10m", raw)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case []int64:
+		var ids []int64
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a Telegram ID", item)
+			}
+			ids = append(ids, id)
+		}
+		field.Set(reflect.ValueOf(ids))
+	case []OutgoingWebhook:
+		var webhooks []OutgoingWebhook
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&webhooks); err != nil {
+			return fmt.Errorf("is not a JSON list of webhooks: %v", err)
+		}
+		field.Set(reflect.ValueOf(webhooks))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+func (c *Config) validate() []error {
+	var problems []error
+	fail := func(key, format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.TelegramToken == "" {
+		fail("telegram_bot_token", "is required")
+	}
+	switch c.Transport {
+	case TransportPolling:
+	case TransportWebhook:
+		if u, err := url.Parse(c.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			fail("webhook_url", "must be an https URL when transport is webhook")
+		}
+		if c.WebhookListen == "" {
+			fail("webhook_listen", "is required when transport is webhook")
+		}
+		// Telegram allows only these characters in the secret token.
+		if len(c.WebhookSecret) < 16 || len(c.WebhookSecret) > 256 || strings.Trim(c.WebhookSecret, webhookSecretChars) != "" {
+			fail("webhook_secret", "must be 16 to 256 letters, digits, _ or - when transport is webhook")
+		}
+	default:
+		fail("transport", "must be %q or %q, got %q", TransportPolling, TransportWebhook, c.Transport)
 	}
+
+	if c.DatabasePath == "" {
+		fail("database_path", "must not be empty")
+	}
+	if c.LogDir == "" {
+		fail("log_dir", "must not be empty")
+	}
+
+	if err := c.Level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("log_level", "must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.Debug {
+		c.Level = slog.LevelDebug
+	}
+	if c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJSON {
+		fail("log_format", "must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, c.LogFormat)
+	}
+
+	c.Location = time.Local
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			fail("timezone", "unknown time zone %q", c.Timezone)
+		} else {
+			c.Location = loc
+		}
+	}
+
+	for _, id := range c.AdminIDs {
+		if id <= 0 {
+			fail("admin_ids", "%d is not a valid Telegram ID", id)
+		}
+	}
+
+	if c.IdeasPerHour < 0 {
+		fail("ideas_per_hour", "must not be negative")
+	}
+	if c.IdeasPerDay < 0 {
+		fail("ideas_per_day", "must not be negative")
+	}
+	if _, err := time.Parse("15:04", c.IdeaDigestTime); err != nil {
+		fail("idea_digest_time", "must be a time of day such as 09:00, got %q", c.IdeaDigestTime)
+	}
+
+	if c.StateTTL < time.Minute {
+		fail("state_ttl", "must be at least 1m")
+	}
+	// Scheduled jobs match the current minute, so a longer tick could skip one.
+	if c.SchedulerTick < time.Second || c.SchedulerTick >= time.Minute {
+		fail("scheduler_tick", "must be at least 1s and shorter than 1m")
+	}
+	if c.UpdateWorkers < 1 || c.UpdateWorkers > 256 {
+		fail("update_workers", "must be between 1 and 256")
+	}
+	if c.UpdateQueueSize < 1 || c.UpdateQueueSize > 10000 {
+		fail("update_queue_size", "must be between 1 and 10000")
+	}
+	if c.EntriesPerPage < 1 || c.EntriesPerPage > 50 {
+		fail("entries_per_page", "must be between 1 and 50")
+	}
+	if c.UsersPerPage < 1 || c.UsersPerPage > 50 {
+		fail("users_per_page", "must be between 1 and 50")
+	}
+
+	for _, origin := range c.APICORSOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			fail("api_cors_origins", "%q is not an origin such as https://example.com or *", origin)
+		}
+	}
+	if c.DeviceSecret != "" {
+		if c.APIListen == "" {
+			fail("device_secret", "needs api_listen to receive device events")
+		}
+		if len(c.DeviceSecret) < 16 {
+			fail("device_secret", "must be at least 16 characters")
+		}
+	}
+	if c.DeviceDebounce < 0 || c.DeviceDebounce > 10*time.Minute {
+		fail("device_debounce", "must be between 0s and 10m")
+	}
+	if c.MetricsListen != "" && c.MetricsListen == c.APIListen {
+		fail("metrics_listen", "must differ from api_listen")
+	}
+	if c.ShutdownTimeout < time.Second || c.ShutdownTimeout > 10*time.Minute {
+		fail("shutdown_timeout", "must be between 1s and 10m")
+	}
+
+	for i, webhook := range c.OutgoingWebhooks {
+		key := fmt.Sprintf("outgoing_webhooks[%d]", i)
+		if u, err := url.Parse(webhook.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			fail(key+".url", "must be an http or https URL")
+		}
+		if len(webhook.Secret) < 16 {
+			fail(key+".secret", "must be at least 16 characters")
+		}
+		for _, event := range webhook.Events {
+			if !slices.Contains(models.WebhookEvents, event) {
+				fail(key+".events", "unknown event %q, expected one of %s", event, strings.Join(models.WebhookEvents, ", "))
+			}
+		}
+	}
+
+	return problems
 }
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+		check func(*Config) any
+		want  any
+	}{
+		{"TELEGRAM_BOT_TOKEN", "123:abc", func(c *Config) any { return c.TelegramToken }, "123:abc"},
+		{"DEBUG", "true", func(c *Config) any { return c.Debug }, true},
+		{"IDEAS_PER_HOUR", "7", func(c *Config) any { return c.IdeasPerHour }, 7},
+		{"STATE_TTL", "15m", func(c *Config) any { return c.StateTTL }, 15 * time.Minute},
+		{"IDEAS_BANNED_WORDS", " spam, ,free money ", func(c *Config) any { return c.IdeasBannedWords }, []string{"spam", "free money"}},
+		{"ADMIN_IDS", "1, 22,", func(c *Config) any { return c.AdminIDs }, []int64{1, 22}},
+		{"OUTGOING_WEBHOOKS", `[{"url":"https://example.com/hook","secret":"s","events":["status.changed"]}]`,
+			func(c *Config) any { return c.OutgoingWebhooks },
+			[]OutgoingWebhook{{URL: "https://example.com/hook", Secret: "s", Events: []string{"status.changed"}}}},
+		// An empty env var keeps the value from the file.
+		{"LOG_LEVEL", "", func(c *Config) any { return c.LogLevel }, "info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			cfg := defaults()
+			if problems := cfg.applyEnv(); len(problems) != 0 {
+				t.Fatalf("applyEnv: %v", problems)
+			}
+			if got := tt.check(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s=%q gave %#v, want %#v", tt.env, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	tests := map[string]string{
+		"DEBUG":             "maybe",
+		"IDEAS_PER_DAY":     "ten",
+		"SCHEDULER_TICK":    "30",
+		"ADMIN_IDS":         "1,@admin",
+		"OUTGOING_WEBHOOKS": `[{"url":"https://example.com","token":"x"}]`,
+	}
+	for env, value := range tests {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			problems := defaults().applyEnv()
+			if len(problems) != 1 || !strings.HasPrefix(problems[0].Error(), env+": ") {
+				t.Errorf("%s=%q gave %v, want one %s problem", env, value, problems, env)
+			}
+		})
+	}
+}
+
+// validConfig returns a configuration that passes validation.
+func validConfig() *Config {
+	cfg := defaults()
+	cfg.TelegramToken = "123:abc"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"no token", func(c *Config) { c.TelegramToken = "" }, []string{"telegram_bot_token"}},
+		{"unknown transport", func(c *Config) { c.Transport = "carrier pigeon" }, []string{"transport"}},
+		{"webhook without settings", func(c *Config) { c.Transport = TransportWebhook; c.WebhookListen = "" },
+			[]string{"webhook_url", "webhook_listen", "webhook_secret"}},
+		{"webhook secret with bad characters", func(c *Config) {
+			c.Transport = TransportWebhook
+			c.WebhookURL = "https://bot.example.com/telegram"
+			c.WebhookSecret = "not allowed: spaces!"
+		}, []string{"webhook_secret"}},
+		{"webhook", func(c *Config) {
+			c.Transport = TransportWebhook
+			c.WebhookURL = "https://bot.example.com/telegram"
+			c.WebhookSecret = "0123456789abcdef_-"
+		}, nil},
+		{"bad log settings", func(c *Config) { c.LogLevel = "loud"; c.LogFormat = "xml" }, []string{"log_level", "log_format"}},
+		{"unknown timezone", func(c *Config) { c.Timezone = "Mars/Olympus" }, []string{"timezone"}},
+		{"negative admin", func(c *Config) { c.AdminIDs = []int64{1, -5} }, []string{"admin_ids"}},
+		{"bad digest time", func(c *Config) { c.IdeaDigestTime = "9am" }, []string{"idea_digest_time"}},
+		{"minute scheduler tick", func(c *Config) { c.SchedulerTick = time.Minute }, []string{"scheduler_tick"}},
+		{"bad cors origin", func(c *Config) { c.APICORSOrigins = []string{"*", "example.com"} }, []string{"api_cors_origins"}},
+		{"device secret without api", func(c *Config) { c.DeviceSecret = "short" }, []string{"device_secret", "device_secret"}},
+		{"shared listener", func(c *Config) { c.APIListen = ":8080"; c.MetricsListen = ":8080" }, []string{"metrics_listen"}},
+		{"bad outgoing webhook", func(c *Config) {
+			c.OutgoingWebhooks = []OutgoingWebhook{{URL: "ftp://example.com", Secret: "short", Events: []string{"nope"}}}
+		}, []string{"outgoing_webhooks[0].url", "outgoing_webhooks[0].secret", "outgoing_webhooks[0].events"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(cfg)
+			var got []string
+			for _, problem := range cfg.validate() {
+				key, _, _ := strings.Cut(problem.Error(), ": ")
+				got = append(got, key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileReportsAllProblems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("transport: smoke signals\nideas_per_hour: -1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("USERS_PER_PAGE", "many")
+	t.Setenv("TELEGRAM_BOT_TOKEN", "")
+
+	_, err := LoadFile(path)
+	if err == nil {
+		t.Fatal("LoadFile accepted an invalid configuration")
+	}
+	want := []string{
+		`USERS_PER_PAGE: "many" is not a whole number`,
+		"telegram_bot_token: is required",
+		`transport: must be "polling" or "webhook", got "smoke signals"`,
+		"ideas_per_hour: must not be negative",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadFile error:\n%s\nwant:\n%s", err, strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadFileEnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("telegram_bot_token: from-file\nideas_per_day: 3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TELEGRAM_BOT_TOKEN", "from-env")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TelegramToken != "from-env" || cfg.IdeasPerDay != 3 {
+		t.Errorf("token %q and ideas_per_day %d, want from-env and 3", cfg.TelegramToken, cfg.IdeasPerDay)
+	}
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"lunobot/models"
 	"strings"
 	"time"
@@ -10,11 +12,13 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	conn timedConn
 }
 
 func NewDB(dataSourceName string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dataSourceName+"?_foreign_keys=on")
+	// The CLI writes to the same database while the bot runs, so wait for its
+	// locks instead of failing right away.
+	conn, err := sql.Open("sqlite3", dataSourceName+"?_foreign_keys=on&_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
@@ -23,7 +27,7 @@ func NewDB(dataSourceName string) (*DB, error) {
 		return nil, err
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: timedConn{conn}}
 	if err := db.init(); err != nil {
 		return nil, err
 	}
@@ -48,7 +52,7 @@ func (db *DB) init() error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
 			username TEXT,
-			content TEXT NOT NULL CHECK (length(content) > 0 AND length(content) <= 4000),
+			content TEXT NOT NULL DEFAULT '' CHECK (length(content) <= 4000),
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(telegram_id)
 		)`,
@@ -74,6 +78,95 @@ func (db *DB) init() error {
 			last_status_by TEXT DEFAULT 'system'
 		)`,
 		`INSERT OR IGNORE INTO auto_close_settings (id) VALUES (1)`,
+		`CREATE TABLE IF NOT EXISTS idea_attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			idea_id INTEGER NOT NULL,
+			file_id TEXT NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('photo', 'document')),
+			file_name TEXT DEFAULT '',
+			FOREIGN KEY (idea_id) REFERENCES ideas(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_idea_attachments_idea_id ON idea_attachments(idea_id)`,
+		`ALTER TABLE users ADD COLUMN idea_alerts TEXT DEFAULT 'off'`,
+		`ALTER TABLE ideas ADD COLUMN status TEXT DEFAULT 'new'`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id INTEGER NOT NULL,
+			actor_name TEXT,
+			action TEXT NOT NULL,
+			target TEXT,
+			before_value TEXT,
+			after_value TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at DESC)`,
+		`ALTER TABLE audit_log ADD COLUMN prev_hash TEXT DEFAULT ''`,
+		`ALTER TABLE audit_log ADD COLUMN hash TEXT DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL COLLATE NOCASE,
+			built_in BOOLEAN DEFAULT FALSE
+		)`,
+		`CREATE TABLE IF NOT EXISTS role_permissions (
+			role_id INTEGER NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (role_id, permission),
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)`,
+		`ALTER TABLE users ADD COLUMN role_id INTEGER REFERENCES roles(id)`,
+		`ALTER TABLE users ADD COLUMN last_seen_at DATETIME`,
+		`ALTER TABLE users ADD COLUMN banned BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN role_expires_at DATETIME`,
+		`ALTER TABLE users ADD COLUMN role_granted_by INTEGER`,
+		`ALTER TABLE users ADD COLUMN previous_role_id INTEGER`,
+		`ALTER TABLE users ADD COLUMN muted BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN restriction_reason TEXT`,
+		`ALTER TABLE users ADD COLUMN restricted_until DATETIME`,
+		`ALTER TABLE users ADD COLUMN membership TEXT DEFAULT 'active'`,
+		`CREATE TABLE IF NOT EXISTS role_requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			telegram_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			decided_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			decided_at DATETIME,
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_role_requests_user ON role_requests(telegram_id, status)`,
+		`CREATE TABLE IF NOT EXISTS invites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT UNIQUE NOT NULL,
+			role_id INTEGER NOT NULL,
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			uses INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME NOT NULL,
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			telegram_id INTEGER NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, query := range queries {
@@ -84,61 +177,207 @@ func (db *DB) init() error {
 		}
 	}
 
-	return nil
+	if err := db.migrateIdeasContentCheck(); err != nil {
+		return err
+	}
+	if err := db.migrateTimesToUTC(); err != nil {
+		return err
+	}
+
+	return db.seedBuiltInRoles()
 }
 
-func (db *DB) GetUserByTelegramID(telegramID int64) (*models.User, error) {
-	user := &models.User{}
-	query := `SELECT id, telegram_id, username, first_name, last_name, rights, 
-			  COALESCE(language, 'ua') as language,
-			  COALESCE(notifications_enabled, 0) as notifications_enabled, created_at, updated_at 
-			  FROM users WHERE telegram_id = ?`
+// seedBuiltInRoles creates the built-in roles, resets their permissions to
+// the defaults and assigns a role to users that predate roles based on their
+// rights level.
+func (db *DB) seedBuiltInRoles() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	err := db.conn.QueryRow(query, telegramID).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.Rights, &user.Language, &user.NotificationsEnabled, &user.CreatedAt, &user.UpdatedAt,
-	)
+	for _, name := range []string{models.RoleUser, models.RoleManager, models.RoleAdmin} {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO roles (name, built_in) VALUES (?, TRUE)`, name); err != nil {
+			return err
+		}
+		var roleID int64
+		if err := tx.QueryRow(`SELECT id FROM roles WHERE name = ?`, name).Scan(&roleID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM role_permissions WHERE role_id = ?`, roleID); err != nil {
+			return err
+		}
+		for _, permission := range models.BuiltInRolePermissions[name] {
+			if _, err := tx.Exec(`INSERT INTO role_permissions (role_id, permission) VALUES (?, ?)`, roleID, permission); err != nil {
+				return err
+			}
+		}
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, models.ErrUserNotFound
+	_, err = tx.Exec(`UPDATE users SET role_id = (SELECT id FROM roles WHERE name = CASE users.rights
+			WHEN 3 THEN 'admin' WHEN 2 THEN 'manager' ELSE 'user' END)
+		WHERE role_id IS NULL`)
+	if err != nil {
+		return err
 	}
 
-	return user, err
+	return tx.Commit()
 }
 
-func (db *DB) GetUserByUsername(username string) (*models.User, error) {
-	user := &models.User{}
-	username = strings.TrimPrefix(username, "@")
+// migrateTimesToUTC rewrites in UTC the times that older versions stored with
+// the offset of the configured time zone, so that they compare correctly
+// with the times stored now.
+func (db *DB) migrateTimesToUTC() error {
+	columns := map[string][]string{
+		"users":   {"last_seen_at", "updated_at", "role_expires_at", "restricted_until"},
+		"invites": {"expires_at"},
+	}
+	for table, names := range columns {
+		for _, column := range names {
+			query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = datetime(%[2]s)
+				WHERE (%[2]s LIKE '%%+__:__' OR %[2]s LIKE '%%-__:__') AND %[2]s NOT LIKE '%%+00:00'`, table, column)
+			if _, err := db.conn.Exec(query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateIdeasContentCheck rebuilds the ideas table created by older versions,
+// whose CHECK constraint rejected empty content and therefore attachment-only ideas.
+func (db *DB) migrateIdeasContentCheck() error {
+	var schema string
+	err := db.conn.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'ideas'`).Scan(&schema)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(schema, "length(content) > 0") {
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `SELECT id, telegram_id, username, first_name, last_name, rights, 
-			  COALESCE(language, 'ua') as language,
-			  COALESCE(notifications_enabled, 0) as notifications_enabled, created_at, updated_at 
-			  FROM users WHERE username = ? COLLATE NOCASE`
+	queries := []string{
+		`CREATE TABLE ideas_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			username TEXT,
+			content TEXT NOT NULL DEFAULT '' CHECK (length(content) <= 4000),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			status TEXT DEFAULT 'new',
+			FOREIGN KEY (user_id) REFERENCES users(telegram_id)
+		)`,
+		`INSERT INTO ideas_new (id, user_id, username, content, created_at, status)
+			SELECT id, user_id, username, content, created_at, status FROM ideas`,
+		`DROP TABLE ideas`,
+		`ALTER TABLE ideas_new RENAME TO ideas`,
+		`CREATE INDEX IF NOT EXISTS idx_ideas_user_id ON ideas(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ideas_created_at ON ideas(created_at DESC)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const userColumns = `id, telegram_id, username, first_name, last_name, rights,
+		  COALESCE(language, 'ua') as language,
+		  COALESCE(notifications_enabled, 0) as notifications_enabled,
+		  COALESCE(idea_alerts, 'off') as idea_alerts, COALESCE(role_id, 0) as role_id,
+		  COALESCE(banned, 0) as banned, last_seen_at, role_expires_at,
+		  COALESCE(role_granted_by, 0) as role_granted_by, COALESCE(previous_role_id, 0) as previous_role_id,
+		  COALESCE(muted, 0) as muted, COALESCE(restriction_reason, '') as restriction_reason, restricted_until,
+		  COALESCE(membership, 'active') as membership,
+		  created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	err := db.conn.QueryRow(query, username).Scan(
+func scanUser(row rowScanner) (*models.User, error) {
+	user := &models.User{}
+	var lastSeenAt, roleExpiresAt, restrictedUntil sql.NullTime
+	err := row.Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.Rights, &user.Language, &user.NotificationsEnabled, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastName, &user.Rights, &user.Language, &user.NotificationsEnabled,
+		&user.IdeaAlerts, &user.RoleID, &user.Banned, &lastSeenAt, &roleExpiresAt,
+		&user.RoleGrantedBy, &user.PreviousRoleID,
+		&user.Muted, &user.RestrictionReason, &restrictedUntil, &user.Membership,
+		&user.CreatedAt, &user.UpdatedAt,
 	)
-
 	if err == sql.ErrNoRows {
 		return nil, models.ErrUserNotFound
 	}
-
+	user.LastSeenAt = user.UpdatedAt
+	if lastSeenAt.Valid {
+		user.LastSeenAt = lastSeenAt.Time
+	}
+	if roleExpiresAt.Valid {
+		user.RoleExpiresAt = roleExpiresAt.Time
+	}
+	if restrictedUntil.Valid {
+		user.RestrictedUntil = restrictedUntil.Time
+	}
 	return user, err
 }
 
+func (db *DB) queryUsers(query string, args ...interface{}) ([]models.User, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (db *DB) GetUserByTelegramID(telegramID int64) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE telegram_id = ?`
+	return scanUser(db.conn.QueryRow(query, telegramID))
+}
+
+func (db *DB) GetUserByUsername(username string) (*models.User, error) {
+	username = strings.TrimPrefix(username, "@")
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = ? COLLATE NOCASE`
+	return scanUser(db.conn.QueryRow(query, username))
+}
+
 func (db *DB) CreateUser(user *models.User) error {
-	query := `INSERT INTO users (telegram_id, username, first_name, last_name, rights, language, notifications_enabled, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO users (telegram_id, username, first_name, last_name, rights, role_id, language, notifications_enabled, membership, last_seen_at, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, (SELECT id FROM roles WHERE name = ?), ?, ?, ?, ?, ?, ?)`
 
 	now := time.Now()
 	if user.Language == "" {
 		user.Language = "ua"
 	}
+	if user.Membership == "" {
+		user.Membership = models.MembershipActive
+	}
 	_, err := db.conn.Exec(query, user.TelegramID, user.Username,
-		user.FirstName, user.LastName, user.Rights, user.Language, user.NotificationsEnabled, now, now)
+		user.FirstName, user.LastName, user.Rights, models.BuiltInRoleForRights(user.Rights),
+		user.Language, user.NotificationsEnabled, user.Membership, now, now, now)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return db.conn.QueryRow(`SELECT COALESCE(role_id, 0) FROM users WHERE telegram_id = ?`, user.TelegramID).Scan(&user.RoleID)
 }
 
 func (db *DB) UpdateUser(user *models.User) error {
@@ -151,13 +390,10 @@ func (db *DB) UpdateUser(user *models.User) error {
 	return err
 }
 
-func (db *DB) UpdateUserRights(telegramID int64, rights models.Rights) error {
-	if !rights.IsValid() {
-		return models.ErrInvalidRights
-	}
-
-	query := `UPDATE users SET rights = ?, updated_at = ? WHERE telegram_id = ?`
-	result, err := db.conn.Exec(query, rights, time.Now(), telegramID)
+func (db *DB) UpdateUserRights(telegramID int64, role *models.Role) error {
+	query := `UPDATE users SET role_id = ?, rights = ?, role_expires_at = NULL, role_granted_by = NULL,
+			  previous_role_id = NULL, updated_at = ? WHERE telegram_id = ?`
+	result, err := db.conn.Exec(query, role.ID, role.Level(), time.Now(), telegramID)
 	if err != nil {
 		return err
 	}
@@ -174,15 +410,12 @@ func (db *DB) UpdateUserRights(telegramID int64, rights models.Rights) error {
 	return nil
 }
 
-func (db *DB) UpdateUserRightsByUsername(username string, rights models.Rights) error {
-	if !rights.IsValid() {
-		return models.ErrInvalidRights
-	}
-
-	username = strings.TrimPrefix(username, "@")
-
-	query := `UPDATE users SET rights = ?, updated_at = ? WHERE username = ? COLLATE NOCASE`
-	result, err := db.conn.Exec(query, rights, time.Now(), username)
+// UpdateUserRightsUntil gives the user a role until expiresAt, after which
+// previousRoleID is restored.
+func (db *DB) UpdateUserRightsUntil(telegramID int64, role *models.Role, previousRoleID, grantedBy int64, expiresAt time.Time) error {
+	query := `UPDATE users SET role_id = ?, rights = ?, role_expires_at = ?, role_granted_by = ?,
+			  previous_role_id = ?, updated_at = ? WHERE telegram_id = ?`
+	result, err := db.conn.Exec(query, role.ID, role.Level(), expiresAt, grantedBy, previousRoleID, time.Now(), telegramID)
 	if err != nil {
 		return err
 	}
@@ -238,85 +471,157 @@ func (db *DB) UpdateUserLanguage(telegramID int64, language string) error {
 }
 
 func (db *DB) GetAllAdmins() ([]models.User, error) {
-	query := `SELECT id, telegram_id, username, first_name, last_name, rights, 
-			  COALESCE(language, 'ua') as language,
-			  COALESCE(notifications_enabled, 0) as notifications_enabled, created_at, updated_at
-              FROM users WHERE rights >= ?`
-	rows, err := db.conn.Query(query, models.RightsManager)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	query := `SELECT ` + userColumns + ` FROM users WHERE rights >= ?`
+	return db.queryUsers(query, models.RightsManager)
+}
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-			&user.LastName, &user.Rights, &user.Language, &user.NotificationsEnabled, &user.CreatedAt, &user.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
+func (db *DB) GetUsersWithNotifications() ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE notifications_enabled = 1 AND COALESCE(banned, 0) = 0
+			  AND COALESCE(membership, 'active') = 'active'`
+	return db.queryUsers(query)
+}
+
+func userFilterClause(filter models.UserFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.RoleID != 0 {
+		conditions = append(conditions, "role_id = ?")
+		args = append(args, filter.RoleID)
+	}
+	if filter.Membership != "" {
+		conditions = append(conditions, "COALESCE(membership, 'active') = ?")
+		args = append(args, filter.Membership)
+	}
+	if filter.Language != "" {
+		conditions = append(conditions, "COALESCE(language, 'ua') = ?")
+		args = append(args, filter.Language)
+	}
+	if filter.Notifications != nil {
+		conditions = append(conditions, "COALESCE(notifications_enabled, 0) = ?")
+		args = append(args, *filter.Notifications)
+	}
+	if !filter.ActiveSince.IsZero() {
+		conditions = append(conditions, "COALESCE(last_seen_at, updated_at) >= ?")
+		args = append(args, filter.ActiveSince)
 	}
-	return users, nil
+	if !filter.InactiveSince.IsZero() {
+		conditions = append(conditions, "COALESCE(last_seen_at, updated_at) < ?")
+		args = append(args, filter.InactiveSince)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
 }
 
-func (db *DB) GetUsersWithNotifications() ([]models.User, error) {
-	query := `SELECT id, telegram_id, username, first_name, last_name, rights, 
-			  COALESCE(language, 'ua') as language,
-			  COALESCE(notifications_enabled, 0) as notifications_enabled, created_at, updated_at
-              FROM users WHERE notifications_enabled = 1`
-	rows, err := db.conn.Query(query)
+func (db *DB) CountUsers(filter models.UserFilter) (int, error) {
+	where, args := userFilterClause(filter)
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&count)
+	return count, err
+}
+
+func (db *DB) GetUsers(filter models.UserFilter, limit, offset int) ([]models.User, error) {
+	where, args := userFilterClause(filter)
+	query := `SELECT ` + userColumns + ` FROM users` + where +
+		` ORDER BY COALESCE(last_seen_at, updated_at) DESC, id DESC LIMIT ? OFFSET ?`
+	return db.queryUsers(query, append(args, limit, offset)...)
+}
+
+func (db *DB) UpdateLastSeen(telegramID int64) error {
+	_, err := db.conn.Exec(`UPDATE users SET last_seen_at = ? WHERE telegram_id = ?`, time.Now(), telegramID)
+	return err
+}
+
+func (db *DB) UpdateUserMembership(telegramID int64, membership models.Membership) error {
+	query := `UPDATE users SET membership = ?, updated_at = ? WHERE telegram_id = ?`
+	result, err := db.conn.Exec(query, membership, time.Now(), telegramID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-			&user.LastName, &user.Rights, &user.Language, &user.NotificationsEnabled, &user.CreatedAt, &user.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrUserNotFound
 	}
-	return users, nil
+
+	return nil
 }
 
-func (db *DB) AddIdea(idea *models.Idea) error {
-	if err := idea.Validate(); err != nil {
+// UpdateUserRestriction bans or mutes the user until the given time, or for
+// good if it is zero. Passing false for both lifts any restriction.
+func (db *DB) UpdateUserRestriction(telegramID int64, banned, muted bool, reason string, until time.Time) error {
+	query := `UPDATE users SET banned = ?, muted = ?, restriction_reason = ?, restricted_until = ?, updated_at = ?
+			  WHERE telegram_id = ?`
+	result, err := db.conn.Exec(query, banned, muted, reason,
+		sql.NullTime{Time: until, Valid: !until.IsZero()}, time.Now(), telegramID)
+	if err != nil {
 		return err
 	}
 
-	query := `INSERT INTO ideas (user_id, username, content) VALUES (?, ?, ?)`
-	_, err := db.conn.Exec(query, idea.UserID, idea.Username, idea.Content)
-	return err
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
 }
 
-func (db *DB) GetIdeaByID(ideaID int64) (*models.Idea, error) {
-	idea := &models.Idea{}
-	query := `SELECT id, user_id, username, content, created_at FROM ideas WHERE id = ?`
+// GetUsersWithIdeaAlerts returns the users who may read ideas and chose mode
+// for their alerts. Banned users get no alerts.
+func (db *DB) GetUsersWithIdeaAlerts(mode models.IdeaAlertMode) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE idea_alerts = ?
+		AND role_id IN (SELECT role_id FROM role_permissions WHERE permission = ?)
+		AND COALESCE(banned, 0) = 0`
+	return db.queryUsers(query, mode, models.PermIdeasRead)
+}
 
-	err := db.conn.QueryRow(query, ideaID).Scan(
-		&idea.ID, &idea.UserID, &idea.Username, &idea.Content, &idea.CreatedAt,
-	)
+func (db *DB) GetUsersWithExpiredRoles(now time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE role_expires_at IS NOT NULL AND role_expires_at <= ?`
+	return db.queryUsers(query, now)
+}
 
-	if err == sql.ErrNoRows {
-		return nil, models.ErrIdeaNotFound
-	}
+func (db *DB) GetUsersWithExpiredRestrictions(now time.Time) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE restricted_until IS NOT NULL AND restricted_until <= ?`
+	return db.queryUsers(query, now)
+}
 
-	return idea, err
+func (db *DB) GetUsersWithRole(roleID int64) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE role_id = ?`
+	return db.queryUsers(query, roleID)
 }
 
-func (db *DB) DeleteIdea(ideaID int64) error {
-	query := `DELETE FROM ideas WHERE id = ?`
-	result, err := db.conn.Exec(query, ideaID)
+// CountUsersWithPermission counts the users whose role grants the permission,
+// leaving out holders of excludeRoleID and banned users, who cannot use it.
+func (db *DB) CountUsersWithPermission(permission models.Permission, excludeRoleID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM users
+		WHERE role_id IN (SELECT role_id FROM role_permissions WHERE permission = ? AND role_id != ?)
+		AND COALESCE(banned, 0) = 0`
+	var count int
+	err := db.conn.QueryRow(query, permission, excludeRoleID).Scan(&count)
+	return count, err
+}
+
+// GetUsersWithPermission returns the users whose role has permission, to be
+// notified. Banned users are left out, as in CountUsersWithPermission.
+func (db *DB) GetUsersWithPermission(permission models.Permission) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE role_id IN (SELECT role_id FROM role_permissions WHERE permission = ?)
+		AND COALESCE(banned, 0) = 0`
+	return db.queryUsers(query, permission)
+}
+
+func (db *DB) UpdateIdeaAlerts(telegramID int64, mode models.IdeaAlertMode) error {
+	query := `UPDATE users SET idea_alerts = ?, updated_at = ? WHERE telegram_id = ?`
+	result, err := db.conn.Exec(query, mode, time.Now(), telegramID)
 	if err != nil {
 		return err
 	}
@@ -327,55 +632,204 @@ func (db *DB) DeleteIdea(ideaID int64) error {
 	}
 
 	if rowsAffected == 0 {
-		return models.ErrIdeaNotFound
+		return models.ErrUserNotFound
 	}
 
 	return nil
 }
 
-func (db *DB) GetAllIdeas() ([]models.Idea, error) {
-	query := `SELECT id, user_id, username, content, created_at FROM ideas ORDER BY created_at DESC`
-	rows, err := db.conn.Query(query)
+func (db *DB) AddIdea(idea *models.Idea) error {
+	if err := idea.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO ideas (user_id, username, content) VALUES (?, ?, ?)`
+	result, err := tx.Exec(query, idea.UserID, idea.Username, idea.Content)
+	if err != nil {
+		return err
+	}
+
+	ideaID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for i := range idea.Attachments {
+		attachment := &idea.Attachments[i]
+		attachment.IdeaID = ideaID
+		result, err := tx.Exec(`INSERT INTO idea_attachments (idea_id, file_id, type, file_name) VALUES (?, ?, ?, ?)`,
+			ideaID, attachment.FileID, attachment.Type, attachment.FileName)
+		if err != nil {
+			return err
+		}
+		if attachment.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	idea.ID = ideaID
+	idea.Status = models.IdeaStatusNew
+	idea.CreatedAt = time.Now()
+	return nil
+}
+
+func (db *DB) GetIdeaAttachments(ideaID int64) ([]models.IdeaAttachment, error) {
+	query := `SELECT id, idea_id, file_id, type, COALESCE(file_name, '') FROM idea_attachments WHERE idea_id = ? ORDER BY id`
+	rows, err := db.conn.Query(query, ideaID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ideas []models.Idea
+	var attachments []models.IdeaAttachment
 	for rows.Next() {
-		var idea models.Idea
-		err := rows.Scan(&idea.ID, &idea.UserID, &idea.Username, &idea.Content, &idea.CreatedAt)
+		var attachment models.IdeaAttachment
+		err := rows.Scan(&attachment.ID, &attachment.IdeaID, &attachment.FileID, &attachment.Type, &attachment.FileName)
 		if err != nil {
 			return nil, err
 		}
-		ideas = append(ideas, idea)
+		attachments = append(attachments, attachment)
 	}
 
-	return ideas, nil
+	return attachments, rows.Err()
 }
 
-func (db *DB) GetStatus() (*models.Status, error) {
-	status := &models.Status{}
-	query := `SELECT id, is_open, technical_status, updated_at, updated_by FROM status WHERE id = 1`
-
-	err := db.conn.QueryRow(query).Scan(
-		&status.ID, &status.IsOpen, &status.TechnicalStatus, &status.UpdatedAt, &status.UpdatedBy,
-	)
+const ideaColumns = `id, user_id, username, content, COALESCE(status, 'new') as status, created_at`
 
-	return status, err
+func scanIdea(row rowScanner) (*models.Idea, error) {
+	idea := &models.Idea{}
+	err := row.Scan(&idea.ID, &idea.UserID, &idea.Username, &idea.Content, &idea.Status, &idea.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrIdeaNotFound
+	}
+	return idea, err
 }
 
-func (db *DB) UpdateOpenStatus(isOpen bool, user *models.User) error {
-	query := `UPDATE status SET is_open = ?, technical_status = ?, updated_at = ?, updated_by = ? WHERE id = 1`
-	_, err := db.conn.Exec(query, isOpen, isOpen, time.Now(), user.GetDisplayName())
-	return err
-}
+func (db *DB) queryIdeas(query string, args ...interface{}) ([]models.Idea, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (db *DB) UpdateTechnicalStatus(technicalStatus bool, user *models.User) error {
-	query := `UPDATE status SET technical_status = ?, updated_at = ?, updated_by = ? WHERE id = 1`
-	_, err := db.conn.Exec(query, technicalStatus, time.Now(), user.GetDisplayName())
-	return err
-}
+	var ideas []models.Idea
+	for rows.Next() {
+		idea, err := scanIdea(rows)
+		if err != nil {
+			return nil, err
+		}
+		ideas = append(ideas, *idea)
+	}
+	return ideas, rows.Err()
+}
+
+func (db *DB) GetIdeaByID(ideaID int64) (*models.Idea, error) {
+	query := `SELECT ` + ideaColumns + ` FROM ideas WHERE id = ?`
+	return scanIdea(db.conn.QueryRow(query, ideaID))
+}
+
+func (db *DB) UpdateIdeaStatus(ideaID int64, status models.IdeaStatus) error {
+	if !status.IsValid() {
+		return models.ErrInvalidIdeaStatus
+	}
+
+	query := `UPDATE ideas SET status = ? WHERE id = ?`
+	result, err := db.conn.Exec(query, status, ideaID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrIdeaNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) DeleteIdea(ideaID int64) error {
+	query := `DELETE FROM ideas WHERE id = ?`
+	result, err := db.conn.Exec(query, ideaID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrIdeaNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) GetAllIdeas() ([]models.Idea, error) {
+	query := `SELECT ` + ideaColumns + ` FROM ideas ORDER BY created_at DESC`
+	return db.queryIdeas(query)
+}
+
+func (db *DB) GetActiveIdeas() ([]models.Idea, error) {
+	query := `SELECT ` + ideaColumns + ` FROM ideas WHERE COALESCE(status, 'new') != ? ORDER BY created_at DESC`
+	return db.queryIdeas(query, models.IdeaStatusArchived)
+}
+
+func (db *DB) GetIdeasSince(since time.Time) ([]models.Idea, error) {
+	query := `SELECT ` + ideaColumns + ` FROM ideas WHERE created_at >= datetime(?, 'unixepoch') ORDER BY created_at DESC`
+	return db.queryIdeas(query, since.Unix())
+}
+
+func (db *DB) CountUserIdeasSince(userID int64, since time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM ideas WHERE user_id = ? AND created_at >= datetime(?, 'unixepoch')`
+	var count int
+	err := db.conn.QueryRow(query, userID, since.Unix()).Scan(&count)
+	return count, err
+}
+
+func (db *DB) GetRecentUserIdeas(userID int64, since time.Time) ([]models.Idea, error) {
+	query := `SELECT ` + ideaColumns + ` FROM ideas
+			  WHERE user_id = ? AND created_at >= datetime(?, 'unixepoch') ORDER BY created_at DESC`
+	return db.queryIdeas(query, userID, since.Unix())
+}
+
+func (db *DB) GetStatus() (*models.Status, error) {
+	status := &models.Status{}
+	query := `SELECT id, is_open, technical_status, updated_at, updated_by FROM status WHERE id = 1`
+
+	err := db.conn.QueryRow(query).Scan(
+		&status.ID, &status.IsOpen, &status.TechnicalStatus, &status.UpdatedAt, &status.UpdatedBy,
+	)
+
+	return status, err
+}
+
+func (db *DB) UpdateOpenStatus(isOpen bool, user *models.User) error {
+	query := `UPDATE status SET is_open = ?, technical_status = ?, updated_at = ?, updated_by = ? WHERE id = 1`
+	_, err := db.conn.Exec(query, isOpen, isOpen, time.Now(), user.GetDisplayName())
+	return err
+}
+
+func (db *DB) UpdateTechnicalStatus(technicalStatus bool, user *models.User) error {
+	query := `UPDATE status SET technical_status = ?, updated_at = ?, updated_by = ? WHERE id = 1`
+	_, err := db.conn.Exec(query, technicalStatus, time.Now(), user.GetDisplayName())
+	return err
+}
 
 func (db *DB) GetAutoCloseSettings() (*models.AutoCloseSettings, error) {
 	settings := &models.AutoCloseSettings{}
@@ -406,6 +860,565 @@ func (db *DB) UpdateOpenStatusAuto(isOpen bool, keysToLobby bool, updatedBy stri
 	return err
 }
 
+const lastAuditHashQuery = `SELECT COALESCE(hash, '') FROM audit_log WHERE COALESCE(hash, '') != '' ORDER BY id DESC LIMIT 1`
+
+// AddChainedAuditEntry appends entry to the audit chain. link is given the
+// hash of the last chained entry, or "" if there is none, and must set the
+// entry's hashes. The head is read and the entry inserted in one immediate
+// transaction, so no other writer, in this process or another, can link an
+// entry to the same head.
+func (db *DB) AddChainedAuditEntry(entry *models.AuditEntry, link func(head string)) error {
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, `ROLLBACK`)
+		}
+	}()
+
+	var head string
+	if err := conn.QueryRowContext(ctx, lastAuditHashQuery).Scan(&head); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	link(head)
+
+	query := `INSERT INTO audit_log (actor_id, actor_name, action, target, before_value, after_value, created_at, prev_hash, hash)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := conn.ExecContext(ctx, query, utcArgs([]interface{}{entry.ActorID, entry.ActorName, entry.Action,
+		entry.Target, entry.Before, entry.After, entry.CreatedAt, entry.PrevHash, entry.Hash})...)
+	if err != nil {
+		return err
+	}
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// auditFilter builds a WHERE clause matching audit actions that start with
+// any of the given prefixes. An empty list matches every action.
+func auditFilter(actionPrefixes []string) (string, []interface{}) {
+	if len(actionPrefixes) == 0 {
+		return "", nil
+	}
+	conditions := make([]string, len(actionPrefixes))
+	args := make([]interface{}, len(actionPrefixes))
+	for i, prefix := range actionPrefixes {
+		conditions[i] = "action LIKE ?"
+		args[i] = prefix + "%"
+	}
+	return " WHERE " + strings.Join(conditions, " OR "), args
+}
+
+func (db *DB) CountAuditEntries(actionPrefixes []string) (int, error) {
+	where, args := auditFilter(actionPrefixes)
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&count)
+	return count, err
+}
+
+const auditColumns = `id, actor_id, COALESCE(actor_name, ''), action, COALESCE(target, ''),
+		  COALESCE(before_value, ''), COALESCE(after_value, ''), created_at,
+		  COALESCE(prev_hash, ''), COALESCE(hash, '')`
+
+func (db *DB) queryAuditEntries(query string, args ...interface{}) ([]models.AuditEntry, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorName, &entry.Action,
+			&entry.Target, &entry.Before, &entry.After, &entry.CreatedAt, &entry.PrevHash, &entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (db *DB) GetAuditEntries(actionPrefixes []string, limit, offset int) ([]models.AuditEntry, error) {
+	where, args := auditFilter(actionPrefixes)
+	query := `SELECT ` + auditColumns + ` FROM audit_log` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	return db.queryAuditEntries(query, append(args, limit, offset)...)
+}
+
+// GetAuditChain returns every audit entry in insertion order.
+func (db *DB) GetAuditChain() ([]models.AuditEntry, error) {
+	return db.queryAuditEntries(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY id`)
+}
+
+func (db *DB) GetLastAuditHash() (string, error) {
+	var hash string
+	err := db.conn.QueryRow(lastAuditHashQuery).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+func (db *DB) loadRolePermissions(roles []models.Role) error {
+	rows, err := db.conn.Query(`SELECT role_id, permission FROM role_permissions ORDER BY role_id, permission`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	permissions := make(map[int64][]models.Permission)
+	for rows.Next() {
+		var roleID int64
+		var permission models.Permission
+		if err := rows.Scan(&roleID, &permission); err != nil {
+			return err
+		}
+		permissions[roleID] = append(permissions[roleID], permission)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range roles {
+		roles[i].Permissions = permissions[roles[i].ID]
+	}
+	return nil
+}
+
+func (db *DB) GetRoles() ([]models.Role, error) {
+	rows, err := db.conn.Query(`SELECT id, name, built_in FROM roles ORDER BY built_in DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.BuiltIn); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadRolePermissions(roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (db *DB) getRole(query string, arg interface{}) (*models.Role, error) {
+	role := &models.Role{}
+	err := db.conn.QueryRow(query, arg).Scan(&role.ID, &role.Name, &role.BuiltIn)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission`, role.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission models.Permission
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		role.Permissions = append(role.Permissions, permission)
+	}
+	return role, rows.Err()
+}
+
+func (db *DB) GetRoleByID(roleID int64) (*models.Role, error) {
+	return db.getRole(`SELECT id, name, built_in FROM roles WHERE id = ?`, roleID)
+}
+
+func (db *DB) GetRoleByName(name string) (*models.Role, error) {
+	return db.getRole(`SELECT id, name, built_in FROM roles WHERE name = ?`, name)
+}
+
+func (db *DB) CreateRole(name string) (*models.Role, error) {
+	result, err := db.conn.Exec(`INSERT INTO roles (name, built_in) VALUES (?, FALSE)`, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, models.ErrDuplicateRole
+		}
+		return nil, err
+	}
+
+	roleID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &models.Role{ID: roleID, Name: name}, nil
+}
+
+func (db *DB) SetRolePermission(roleID int64, permission models.Permission, granted bool) error {
+	var err error
+	if granted {
+		_, err = db.conn.Exec(`INSERT OR IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)`, roleID, permission)
+	} else {
+		_, err = db.conn.Exec(`DELETE FROM role_permissions WHERE role_id = ? AND permission = ?`, roleID, permission)
+	}
+	return err
+}
+
+// SyncRoleRights updates the legacy rights level of every user holding the role.
+func (db *DB) SyncRoleRights(role *models.Role) error {
+	_, err := db.conn.Exec(`UPDATE users SET rights = ?, updated_at = ? WHERE role_id = ?`, role.Level(), time.Now(), role.ID)
+	return err
+}
+
+func (db *DB) CountUsersWithRole(roleID int64) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM users WHERE role_id = ?`, roleID).Scan(&count)
+	return count, err
+}
+
+func (db *DB) DeleteRole(roleID int64) error {
+	result, err := db.conn.Exec(`DELETE FROM roles WHERE id = ? AND built_in = FALSE`, roleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrRoleNotFound
+	}
+
+	return nil
+}
+
+const inviteColumns = `id, code, role_id, max_uses, uses, expires_at, created_by, created_at`
+
+func scanInvite(row rowScanner) (*models.Invite, error) {
+	invite := &models.Invite{}
+	err := row.Scan(&invite.ID, &invite.Code, &invite.RoleID, &invite.MaxUses, &invite.Uses,
+		&invite.ExpiresAt, &invite.CreatedBy, &invite.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrInviteNotFound
+	}
+	return invite, err
+}
+
+func (db *DB) CreateInvite(invite *models.Invite) error {
+	query := `INSERT INTO invites (code, role_id, max_uses, expires_at, created_by, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?)`
+	invite.CreatedAt = time.Now()
+	result, err := db.conn.Exec(query, invite.Code, invite.RoleID, invite.MaxUses,
+		invite.ExpiresAt, invite.CreatedBy, invite.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	invite.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) GetInviteByCode(code string) (*models.Invite, error) {
+	query := `SELECT ` + inviteColumns + ` FROM invites WHERE code = ?`
+	return scanInvite(db.conn.QueryRow(query, code))
+}
+
+func (db *DB) GetActiveInvites(now time.Time) ([]models.Invite, error) {
+	query := `SELECT ` + inviteColumns + ` FROM invites
+			  WHERE uses < max_uses AND expires_at > ? ORDER BY created_at DESC`
+	rows, err := db.conn.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// UseInvite counts one use of the invite, failing if it has expired or has
+// no uses left.
+func (db *DB) UseInvite(inviteID int64, now time.Time) error {
+	query := `UPDATE invites SET uses = uses + 1 WHERE id = ? AND uses < max_uses AND expires_at > ?`
+	result, err := db.conn.Exec(query, inviteID, now)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrInviteUsedUp
+	}
+
+	return nil
+}
+
+// RefundInvite gives back a use taken by UseInvite.
+func (db *DB) RefundInvite(inviteID int64) error {
+	_, err := db.conn.Exec(`UPDATE invites SET uses = uses - 1 WHERE id = ? AND uses > 0`, inviteID)
+	return err
+}
+
+func (db *DB) DeleteInvite(inviteID int64) error {
+	result, err := db.conn.Exec(`DELETE FROM invites WHERE id = ?`, inviteID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrInviteNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) CreateRoleRequest(request *models.RoleRequest) error {
+	query := `INSERT INTO role_requests (telegram_id, role_id, reason, status, created_at) VALUES (?, ?, ?, ?, ?)`
+	request.Status = models.RoleRequestPending
+	request.CreatedAt = time.Now()
+	result, err := db.conn.Exec(query, request.TelegramID, request.RoleID, request.Reason, request.Status, request.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	request.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) GetRoleRequest(requestID int64) (*models.RoleRequest, error) {
+	query := `SELECT id, telegram_id, role_id, reason, status, COALESCE(decided_by, 0), created_at
+			  FROM role_requests WHERE id = ?`
+	request := &models.RoleRequest{}
+	err := db.conn.QueryRow(query, requestID).Scan(&request.ID, &request.TelegramID, &request.RoleID,
+		&request.Reason, &request.Status, &request.DecidedBy, &request.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrRoleRequestNotFound
+	}
+	return request, err
+}
+
+func (db *DB) HasPendingRoleRequest(telegramID int64) (bool, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM role_requests WHERE telegram_id = ? AND status = ?`,
+		telegramID, models.RoleRequestPending).Scan(&count)
+	return count > 0, err
+}
+
+// DecideRoleRequest records the decision on a pending request. It fails with
+// ErrRoleRequestDecided if someone has decided it already.
+func (db *DB) DecideRoleRequest(requestID int64, status models.RoleRequestStatus, decidedBy int64) error {
+	query := `UPDATE role_requests SET status = ?, decided_by = ?, decided_at = ? WHERE id = ? AND status = ?`
+	result, err := db.conn.Exec(query, status, decidedBy, time.Now(), requestID, models.RoleRequestPending)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrRoleRequestDecided
+	}
+
+	return nil
+}
+
+// ReopenRoleRequest puts an approved request back to pending, for when its
+// role could not be granted after all.
+func (db *DB) ReopenRoleRequest(requestID int64) error {
+	query := `UPDATE role_requests SET status = ?, decided_by = NULL, decided_at = NULL WHERE id = ? AND status = ?`
+	_, err := db.conn.Exec(query, models.RoleRequestPending, requestID, models.RoleRequestApproved)
+	return err
+}
+
+const apiTokenColumns = `id, name, telegram_id, token_hash, created_at, last_used_at`
+
+func scanAPIToken(row rowScanner) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.Name, &token.TelegramID, &token.TokenHash, &token.CreatedAt, &lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrAPITokenNotFound
+	}
+	token.LastUsedAt = lastUsedAt.Time
+	return token, err
+}
+
+func (db *DB) CreateAPIToken(token *models.APIToken) error {
+	query := `INSERT INTO api_tokens (name, telegram_id, token_hash, created_at) VALUES (?, ?, ?, ?)`
+	token.CreatedAt = time.Now()
+	result, err := db.conn.Exec(query, token.Name, token.TelegramID, token.TokenHash, token.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	token.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = ?`
+	return scanAPIToken(db.conn.QueryRow(query, hash))
+}
+
+func (db *DB) GetAPITokens() ([]models.APIToken, error) {
+	rows, err := db.conn.Query(`SELECT ` + apiTokenColumns + ` FROM api_tokens ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (db *DB) UpdateAPITokenLastUsed(tokenID int64, now time.Time) error {
+	_, err := db.conn.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, tokenID)
+	return err
+}
+
+func (db *DB) DeleteAPIToken(tokenID int64) error {
+	result, err := db.conn.Exec(`DELETE FROM api_tokens WHERE id = ?`, tokenID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return models.ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+func (db *DB) CreateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	query := `INSERT INTO webhook_deliveries (url, event, payload, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	result, err := db.conn.Exec(query, delivery.URL, delivery.Event, delivery.Payload, delivery.Status,
+		delivery.CreatedAt, delivery.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	delivery.ID, err = result.LastInsertId()
+	return err
+}
+
+// UpdateWebhookDelivery saves the outcome of the latest delivery attempt.
+func (db *DB) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?, error = ?, updated_at = ? WHERE id = ?`
+	delivery.UpdatedAt = time.Now()
+	_, err := db.conn.Exec(query, delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error,
+		delivery.UpdatedAt, delivery.ID)
+	return err
+}
+
+func (db *DB) CountWebhookDeliveries() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&count)
+	return count, err
+}
+
+func (db *DB) GetWebhookDeliveries(limit, offset int) ([]models.WebhookDelivery, error) {
+	query := `SELECT id, url, event, payload, status, attempts, response_code, error, created_at, updated_at
+			  FROM webhook_deliveries ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := db.conn.Query(query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// GetPendingWebhookDeliveries returns the deliveries still being attempted,
+// oldest first.
+func (db *DB) GetPendingWebhookDeliveries() ([]models.WebhookDelivery, error) {
+	query := `SELECT id, url, event, payload, status, attempts, response_code, error, created_at, updated_at
+			  FROM webhook_deliveries WHERE status = ? ORDER BY id`
+	rows, err := db.conn.Query(query, models.WebhookDeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		err := rows.Scan(&d.ID, &d.URL, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseCode, &d.Error, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
+
+func (db *DB) Ping() error {
+	return db.conn.Ping()
+}
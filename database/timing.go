@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"lunobot/metrics"
+	"strings"
+	"time"
+)
+
+// timedConn records how long each statement run on the connection takes.
+// It also stores every time in UTC: the driver writes times as text with
+// their offset and rows are compared as text, so they must share one zone.
+type timedConn struct {
+	*sql.DB
+}
+
+func (c timedConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return c.DB.Exec(query, utcArgs(args)...)
+}
+
+func (c timedConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return c.DB.Query(query, utcArgs(args)...)
+}
+
+func (c timedConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return c.DB.QueryRow(query, utcArgs(args)...)
+}
+
+func utcArgs(args []interface{}) []interface{} {
+	for i, arg := range args {
+		switch t := arg.(type) {
+		case time.Time:
+			args[i] = t.UTC()
+		case sql.NullTime:
+			t.Time = t.Time.UTC()
+			args[i] = t
+		}
+	}
+	return args
+}
+
+// observeQuery labels the statement by its leading keyword, e.g. "select".
+func observeQuery(query string, start time.Time) {
+	statement := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		switch keyword := strings.ToLower(fields[0]); keyword {
+		case "select", "insert", "update", "delete", "create", "alter", "pragma":
+			statement = keyword
+		}
+	}
+	metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), statement)
+}
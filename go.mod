@@ -7,4 +7,4 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.30
 )
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
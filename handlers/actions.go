@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strings"
+	"time"
+)
+
+// The actions below change state on behalf of a user. Both the Telegram
+// handlers and the admin API go through them, so that every change is
+// validated, audited and announced the same way. Callers check permissions.
+
+const maxBroadcastLength = 4000
+
+// SetOpenStatus opens or closes Lunoteka. Opening notifies subscribers.
+func (h *BotHandlers) SetOpenStatus(ctx context.Context, user *models.User, isOpen bool) error {
+	before := ""
+	if status, err := h.statusService.GetStatus(); err == nil {
+		before = openStatusCode(status.IsOpen)
+	}
+
+	if err := h.statusService.UpdateOpenStatus(ctx, isOpen, user); err != nil {
+		return err
+	}
+
+	h.audit(ctx, user, models.AuditStatusChange, "lunoteka", before, openStatusCode(isOpen))
+
+	if err := h.logService.LogStatusChange(ctx, isOpen, user.GetDisplayName()); err != nil {
+		slog.ErrorContext(ctx, "Error logging status change", "error", err)
+	}
+
+	h.schedulerService.UpdateLastUser(user.GetDisplayName())
+	h.webhookService.Emit(ctx, models.WebhookStatusChanged, statusWebhookData{
+		Open: isOpen,
+		By:   user.GetDisplayName(),
+	})
+
+	if isOpen {
+		h.goBackground(func() {
+			if count, err := h.broadcastService.SendOpenNotification(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error sending open notifications", "error", err)
+			} else {
+				slog.InfoContext(ctx, "Open notifications sent", "count", count)
+			}
+		})
+	}
+	return nil
+}
+
+// SetKeysLocation records whether the keys are with the admin or in the lobby.
+func (h *BotHandlers) SetKeysLocation(ctx context.Context, user *models.User, atAdmin bool) error {
+	before := ""
+	if status, err := h.statusService.GetStatus(); err == nil {
+		before = keysLocationCode(status.TechnicalStatus)
+	}
+
+	if err := h.statusService.UpdateTechnicalStatus(ctx, atAdmin, user); err != nil {
+		return err
+	}
+
+	h.audit(ctx, user, models.AuditKeysChange, "keys", before, keysLocationCode(atAdmin))
+
+	if err := h.logService.LogKeysChange(ctx, atAdmin, user.GetDisplayName()); err != nil {
+		slog.ErrorContext(ctx, "Error logging keys change", "error", err)
+	}
+	h.webhookService.Emit(ctx, models.WebhookKeysMoved, keysWebhookData{
+		Location: keysLocationCode(atAdmin),
+		By:       user.GetDisplayName(),
+	})
+	return nil
+}
+
+// DeleteIdea deletes the idea, returning models.ErrIdeaNotFound if there is
+// no such idea.
+func (h *BotHandlers) DeleteIdea(ctx context.Context, user *models.User, ideaID int64) error {
+	before := ""
+	if idea, err := h.ideaService.GetIdeaByID(ideaID); err == nil {
+		before = idea.Content
+	}
+	if err := h.ideaService.DeleteIdea(ideaID); err != nil {
+		return err
+	}
+	h.audit(ctx, user, models.AuditIdeaDelete, fmt.Sprintf("idea #%d", ideaID), before, "")
+	return nil
+}
+
+// ChangeRole gives target the role until expiresAt, or for good if it is
+// zero, and tells target about it. Demoting an admin must be confirmed by
+// the caller beforehand.
+func (h *BotHandlers) ChangeRole(ctx context.Context, user, target *models.User, role *models.Role, expiresAt time.Time) error {
+	var err error
+	if expiresAt.IsZero() {
+		err = h.userService.UpdateUserRights(ctx, target.TelegramID, role)
+	} else {
+		err = h.userService.GrantTemporaryRole(ctx, target.TelegramID, role, user.TelegramID, expiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	after := role.Name
+	suffix := ""
+	if !expiresAt.IsZero() {
+		after += " until " + h.localTime(expiresAt).Format("02.01.2006 15:04")
+		suffix = "_until"
+	}
+	h.audit(ctx, user, models.AuditRightsChange, target.GetDisplayName(), h.roleCode(target), after)
+	if target.TelegramID != user.TelegramID {
+		h.sendMessage(ctx, target.TelegramID, h.tParams("rights_changed_notice"+suffix, target, map[string]string{
+			"rights": h.menu.RoleName(role, h.getUserLang(target)),
+			"admin":  user.GetDisplayName(),
+			"until":  h.localTime(expiresAt).Format("02.01.2006 15:04"),
+		}))
+	}
+	return nil
+}
+
+// SendBroadcast sends an alert to every admin and returns how many received it.
+func (h *BotHandlers) SendBroadcast(ctx context.Context, user *models.User, text string) (int, error) {
+	if strings.TrimSpace(text) == "" {
+		return 0, models.ErrEmptyBroadcast
+	}
+	if len(text) > maxBroadcastLength {
+		return 0, models.ErrBroadcastTooLong
+	}
+
+	sentCount, err := h.broadcastService.SendBroadcast(ctx, text)
+	if err != nil {
+		return 0, err
+	}
+	h.audit(ctx, user, models.AuditBroadcastSend, fmt.Sprintf("%d recipients", sentCount), "", text)
+	return sentCount, nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"lunobot/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// albumWait is how long the parts of an album are collected before they are
+// saved as one idea. Telegram sends each photo of an album as a message of its
+// own, right after one another, and puts the caption on one of them.
+const albumWait = 2 * time.Second
+
+type pendingAlbum struct {
+	content     string
+	attachments []models.IdeaAttachment
+}
+
+// collectAlbumPart adds a message of an album to the idea being collected.
+// The first part starts the wait, after which the album is submitted as one
+// idea with every file attached.
+func (h *BotHandlers) collectAlbumPart(ctx context.Context, message *tgbotapi.Message, user *models.User) {
+	groupID := message.MediaGroupID
+
+	h.albumMutex.Lock()
+	album, collecting := h.albums[groupID]
+	if !collecting {
+		album = &pendingAlbum{}
+		h.albums[groupID] = album
+	}
+	if album.content == "" {
+		album.content = message.Caption
+	}
+	album.attachments = append(album.attachments, ideaAttachmentsFromMessage(message)...)
+	h.albumMutex.Unlock()
+	if collecting {
+		return
+	}
+
+	h.goBackground(func() {
+		timer := time.NewTimer(albumWait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-h.done:
+		}
+
+		h.albumMutex.Lock()
+		album := h.albums[groupID]
+		delete(h.albums, groupID)
+		h.albumMutex.Unlock()
+		h.submitIdea(ctx, message.Chat.ID, message.From, user, album.content, album.attachments)
+	})
+}
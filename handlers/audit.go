@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"lunobot/services"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (h *BotHandlers) audit(ctx context.Context, actor *models.User, action, target, before, after string) {
+	if err := h.auditService.Record(ctx, actor, action, target, before, after); err != nil {
+		slog.ErrorContext(ctx, "Error recording audit entry", "action", action, "actor_id", actor.TelegramID, "error", err)
+	}
+}
+
+// roleCode returns the name of the user's role as stored, for audit entries.
+func (h *BotHandlers) roleCode(user *models.User) string {
+	if user.RoleID != 0 {
+		if role, err := h.roleService.GetRoleByID(user.RoleID); err == nil {
+			return role.Name
+		}
+	}
+	return models.BuiltInRoleForRights(user.Rights)
+}
+
+func openStatusCode(isOpen bool) string {
+	if isOpen {
+		return "open"
+	}
+	return "closed"
+}
+
+func keysLocationCode(atAdmin bool) string {
+	if atAdmin {
+		return "admin"
+	}
+	return "lobby"
+}
+
+func autoCloseSummary(settings *models.AutoCloseSettings) string {
+	keys := "admin"
+	if settings.KeysToLobby {
+		keys = "lobby"
+	}
+	return fmt.Sprintf("enabled=%t time=%s keys=%s", settings.Enabled, settings.CloseTime, keys)
+}
+
+func (h *BotHandlers) handleAuditMenu(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(services.AuditFilters); i += 2 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, filter := range services.AuditFilters[i:min(i+2, len(services.AuditFilters))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				h.t("audit_filter_"+filter, user), fmt.Sprintf("audit_%s_1", filter),
+			))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("audit_menu_header", user), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleAuditPage(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "audit_"), "_")
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	filter := parts[0]
+	page, err := strconv.Atoi(parts[1])
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	entries, totalPages, err := h.auditService.GetEntries(filter, page)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting audit entries", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	if len(entries) == 0 {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "audit_log"),
+			),
+		)
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("audit_empty", user), keyboard)
+		return
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	text := h.tParams("audit_header", user, map[string]string{
+		"filter":      h.t("audit_filter_"+filter, user),
+		"page":        strconv.Itoa(page),
+		"total_pages": strconv.Itoa(totalPages),
+	})
+	for _, entry := range entries {
+		text += h.formatAuditEntry(entry, user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var navRow []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("audit_%s_%d", filter, page-1)))
+	}
+	if page < totalPages {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("audit_%s_%d", filter, page+1)))
+	}
+	if len(navRow) > 0 {
+		rows = append(rows, navRow)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "audit_log"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) formatAuditEntry(entry models.AuditEntry, user *models.User) string {
+	action := h.t("audit_action_"+strings.ReplaceAll(entry.Action, ".", "_"), user)
+	text := h.tParams("audit_entry", user, map[string]string{
+		"date":   h.localTime(entry.CreatedAt).Format("02.01.2006"),
+		"time":   h.localTime(entry.CreatedAt).Format("15:04:05"),
+		"actor":  entry.ActorName,
+		"action": action,
+		"target": entry.Target,
+	})
+	if entry.Before != "" || entry.After != "" {
+		text += h.tParams("audit_entry_change", user, map[string]string{
+			"before": previewText(entry.Before, ideaDigestPreviewLength),
+			"after":  previewText(entry.After, ideaDigestPreviewLength),
+		})
+	}
+	return text + "\n"
+}
+
+func (h *BotHandlers) handleVerifyLogs(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "status_logs"),
+		),
+	)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.formatChainVerification(ctx, user), keyboard)
+}
+
+func (h *BotHandlers) formatChainVerification(ctx context.Context, user *models.User) string {
+	text := h.t("chain_verify_header", user)
+
+	chainBreak, verified, err := h.logService.VerifyChain()
+	text += h.formatChainResult(ctx, "chain_verify_status_log", chainBreak, verified, err, user)
+
+	chainBreak, verified, err = h.auditService.VerifyChain()
+	text += h.formatChainResult(ctx, "chain_verify_audit_log", chainBreak, verified, err, user)
+
+	return text
+}
+
+func (h *BotHandlers) formatChainResult(ctx context.Context, nameKey string, chainBreak *services.ChainBreak, verified int, err error, user *models.User) string {
+	name := h.t(nameKey, user)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error verifying chain", "chain", nameKey, "error", err)
+		return h.tParams("chain_verify_error", user, map[string]string{"name": name}) + "\n"
+	}
+	if chainBreak == nil {
+		return h.tParams("chain_verify_ok", user, map[string]string{
+			"name":  name,
+			"count": strconv.Itoa(verified),
+		}) + "\n"
+	}
+	return h.tParams("chain_verify_broken", user, map[string]string{
+		"name":   name,
+		"source": chainBreak.Source,
+		"record": strconv.Itoa(chainBreak.Record),
+		"time":   h.localTime(chainBreak.Timestamp).Format("02.01.2006 15:04:05"),
+		"reason": chainBreak.Reason,
+		"count":  strconv.Itoa(verified),
+	}) + "\n"
+}
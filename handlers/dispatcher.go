@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"lunobot/metrics"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// updateDispatcher hands updates to a fixed number of workers. Updates from
+// the same user always go to the same worker, so they are handled one at a
+// time and in order, while different users are served in parallel.
+type updateDispatcher struct {
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newUpdateDispatcher(workers, queueSize int, handle func(tgbotapi.Update)) *updateDispatcher {
+	d := &updateDispatcher{queues: make([]chan tgbotapi.Update, workers)}
+	for i := range d.queues {
+		queue := make(chan tgbotapi.Update, queueSize)
+		d.queues[i] = queue
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for update := range queue {
+				handle(update)
+			}
+		}()
+	}
+	return d
+}
+
+// dispatch queues the update for its user's worker. If that worker's queue
+// is full, it waits, which holds back polling or the webhook request, until
+// there is room or ctx is done. In the latter case the update is rejected and
+// dispatch returns false.
+func (d *updateDispatcher) dispatch(ctx context.Context, update tgbotapi.Update) bool {
+	var key int64
+	if from := update.SentFrom(); from != nil {
+		key = from.ID
+	} else if chat := update.FromChat(); chat != nil {
+		key = chat.ID
+	}
+	queue := d.queues[uint64(key)%uint64(len(d.queues))]
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		metrics.UpdatesRejected.Inc()
+		return false
+	}
+
+	// Queue the update whenever there is room, even if ctx is already done.
+	select {
+	case queue <- update:
+		return true
+	default:
+	}
+	select {
+	case queue <- update:
+		return true
+	case <-ctx.Done():
+		metrics.UpdatesRejected.Inc()
+		return false
+	}
+}
+
+// depth returns the number of updates waiting for a worker.
+func (d *updateDispatcher) depth() float64 {
+	total := 0
+	for _, queue := range d.queues {
+		total += len(queue)
+	}
+	return float64(total)
+}
+
+// stop lets the workers finish the queued updates and waits for them.
+// Updates dispatched afterwards are rejected.
+func (d *updateDispatcher) stop() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
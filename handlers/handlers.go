@@ -3,14 +3,18 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"lunobot/i18n"
+	"lunobot/logging"
 	"lunobot/menu"
+	"lunobot/metrics"
 	"lunobot/models"
 	"lunobot/services"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -23,17 +27,31 @@ type UserState struct {
 }
 
 type BotHandlers struct {
-	bot              *tgbotapi.BotAPI
-	userService      *services.UserService
-	ideaService      *services.IdeaService
-	statusService    *services.StatusService
-	broadcastService *services.BroadcastService
-	schedulerService *services.SchedulerService
-	logService       *services.LogService
-	menu             *menu.MenuGenerator
-	translator       *i18n.Translator
-	userStates       map[int64]*UserState
-	stateMutex       sync.RWMutex
+	bot                *tgbotapi.BotAPI
+	userService        *services.UserService
+	ideaService        *services.IdeaService
+	statusService      *services.StatusService
+	broadcastService   *services.BroadcastService
+	schedulerService   *services.SchedulerService
+	logService         *services.LogService
+	auditService       *services.AuditService
+	roleService        *services.RoleService
+	inviteService      *services.InviteService
+	roleRequestService *services.RoleRequestService
+	webhookService     *services.WebhookService
+	menu               *menu.MenuGenerator
+	translator         *i18n.Translator
+	userStates         map[int64]*UserState
+	stateTTL           time.Duration
+	location           *time.Location
+	stateMutex         sync.RWMutex
+	albums             map[string]*pendingAlbum
+	albumMutex         sync.Mutex
+	lastPoll           atomic.Int64
+	updates            *updateDispatcher
+	background         sync.WaitGroup
+	done               chan struct{}
+	shutdownOnce       sync.Once
 }
 
 func NewBotHandlers(
@@ -44,24 +62,50 @@ func NewBotHandlers(
 	broadcastService *services.BroadcastService,
 	schedulerService *services.SchedulerService,
 	logService *services.LogService,
+	auditService *services.AuditService,
+	roleService *services.RoleService,
+	inviteService *services.InviteService,
+	roleRequestService *services.RoleRequestService,
+	webhookService *services.WebhookService,
+	stateTTL time.Duration,
+	workers, queueSize int,
+	location *time.Location,
 ) *BotHandlers {
 	translator := i18n.NewTranslator()
 	h := &BotHandlers{
-		bot:              bot,
-		userService:      userService,
-		ideaService:      ideaService,
-		statusService:    statusService,
-		broadcastService: broadcastService,
-		schedulerService: schedulerService,
-		logService:       logService,
-		menu:             menu.NewMenuGenerator(translator),
-		translator:       translator,
-		userStates:       make(map[int64]*UserState),
-	}
+		bot:                bot,
+		userService:        userService,
+		ideaService:        ideaService,
+		statusService:      statusService,
+		broadcastService:   broadcastService,
+		schedulerService:   schedulerService,
+		logService:         logService,
+		auditService:       auditService,
+		roleService:        roleService,
+		inviteService:      inviteService,
+		roleRequestService: roleRequestService,
+		webhookService:     webhookService,
+		menu:               menu.NewMenuGenerator(translator),
+		translator:         translator,
+		userStates:         make(map[int64]*UserState),
+		albums:             make(map[string]*pendingAlbum),
+		stateTTL:           stateTTL,
+		location:           location,
+		done:               make(chan struct{}),
+	}
+	h.updates = newUpdateDispatcher(workers, queueSize, h.HandleUpdate)
+	metrics.ConversationStates.Set(h.countUserStates)
+	metrics.UpdateQueueDepth.Set(h.updates.depth)
 	go h.cleanupExpiredStates()
 	return h
 }
 
+// localTime converts t to the configured time zone for display. Times are
+// kept in UTC everywhere else.
+func (h *BotHandlers) localTime(t time.Time) time.Time {
+	return t.In(h.location)
+}
+
 func (h *BotHandlers) getUserLang(user *models.User) i18n.Language {
 	return i18n.ParseLanguage(user.Language)
 }
@@ -74,237 +118,358 @@ func (h *BotHandlers) tParams(key string, user *models.User, params map[string]s
 	return h.translator.GetWithParams(key, h.getUserLang(user), params)
 }
 
-func (h *BotHandlers) Start(ctx context.Context) {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates := h.bot.GetUpdatesChan(u)
-	for {
-		select {
-		case update := <-updates:
-			go h.HandleUpdate(update)
-		case <-ctx.Done():
-			log.Println("Bot stopped")
-			return
-		}
-	}
-}
-
+// HandleUpdate handles one update. Everything it logs carries a fresh
+// correlation ID along with the user ID and route of the update.
 func (h *BotHandlers) HandleUpdate(update tgbotapi.Update) {
+	ctx := logging.NewCorrelation(context.Background())
+	ctx = logging.With(ctx, slog.Int("update_id", update.UpdateID))
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Panic in HandleUpdate: %v", r)
+			slog.ErrorContext(ctx, "Panic while handling update", "panic", r, "stack", string(debug.Stack()))
 		}
 	}()
+	metrics.UpdatesHandled.Inc(updateType(update))
+
+	var from *tgbotapi.User
+	var chatID int64
 	if update.Message != nil {
-		h.handleMessage(update.Message)
+		from, chatID = update.Message.From, update.Message.Chat.ID
+		route := "message"
+		if command := update.Message.Command(); command != "" {
+			route = "/" + command
+		}
+		ctx = logging.With(ctx, slog.Int64("user_id", from.ID), slog.String("route", route))
 	} else if update.CallbackQuery != nil {
-		h.handleCallback(update.CallbackQuery)
+		from, chatID = update.CallbackQuery.From, update.CallbackQuery.Message.Chat.ID
+		ctx = logging.With(ctx, slog.Int64("user_id", from.ID),
+			slog.String("callback_data", update.CallbackQuery.Data),
+			slog.String("route", callbackRoute(update.CallbackQuery.Data)))
+		h.answerCallback(update.CallbackQuery.ID, "")
+	} else {
+		return
 	}
-}
+	slog.DebugContext(ctx, "Handling update")
 
-func (h *BotHandlers) handleMessage(message *tgbotapi.Message) {
-	user, err := h.userService.GetOrCreateUser(
-		message.From.ID,
-		message.From.UserName,
-		message.From.FirstName,
-		message.From.LastName,
-	)
+	user, err := h.userService.GetOrCreateUser(ctx, from.ID, from.UserName, from.FirstName, from.LastName)
 	if err != nil {
-		log.Printf("Error getting/creating user: %v", err)
-		h.sendMessage(message.Chat.ID, "❌ An error occurred. Please try again later.")
+		slog.ErrorContext(ctx, "Error getting or creating user", "error", err)
+		h.sendMessage(ctx, chatID, "❌ An error occurred. Please try again later.")
+		return
+	}
+	if user.Banned {
+		h.sendMessage(ctx, chatID, h.restrictionNotice("banned_notice", user))
 		return
 	}
+	// Pending users may only redeem an invite, which lets them in.
+	if user.Membership == models.MembershipRejected ||
+		user.Membership == models.MembershipPending && !isInviteStart(update.Message) {
+		h.sendMessage(ctx, chatID, h.t("membership_"+string(user.Membership)+"_notice", user))
+		return
+	}
+
+	if update.Message != nil {
+		h.handleMessage(ctx, update.Message, user)
+	} else {
+		h.routeCallback(ctx, update.CallbackQuery, user)
+	}
+}
+
+func (h *BotHandlers) handleMessage(ctx context.Context, message *tgbotapi.Message, user *models.User) {
 	switch message.Command() {
 	case "start":
 		h.clearUserState(message.From.ID)
-		h.sendLanguageSelection(message.Chat.ID)
+		if payload := message.CommandArguments(); strings.HasPrefix(payload, services.InvitePrefix) {
+			h.redeemInvite(ctx, message.Chat.ID, user, payload)
+		}
+		if user.Membership != models.MembershipActive {
+			h.sendMessage(ctx, message.Chat.ID, h.t("membership_pending_notice", user))
+			return
+		}
+		h.sendLanguageSelection(ctx, message.Chat.ID)
 	case "help":
-		h.sendHelpMessage(message.Chat.ID, user)
+		h.sendHelpMessage(ctx, message.Chat.ID, user)
 	case "cancel":
+		if state := h.getUserState(message.From.ID); state != nil && state.State == "waiting_username" {
+			h.sendWithoutReplyKeyboard(ctx, message.Chat.ID, h.t("action_cancelled", user))
+		}
 		h.clearUserState(message.From.ID)
-		h.sendMainMenu(message.Chat.ID, user)
+		h.sendMainMenu(ctx, message.Chat.ID, user)
+	case "verify":
+		if user.HasPermission(models.PermLogsRead) {
+			h.sendMessage(ctx, message.Chat.ID, h.formatChainVerification(ctx, user))
+		} else {
+			h.sendMessage(ctx, message.Chat.ID, h.t("error_unknown_command", user))
+		}
 	default:
 		if state := h.getUserState(message.From.ID); state != nil {
-			h.handleUserState(message, state, user)
+			h.handleUserState(ctx, message, state, user)
 		} else {
-			h.sendMainMenu(message.Chat.ID, user)
+			h.sendMainMenu(ctx, message.Chat.ID, user)
 		}
 	}
 }
 
-func (h *BotHandlers) handleCallback(callback *tgbotapi.CallbackQuery) {
-	h.answerCallback(callback.ID, "")
-
-	user, err := h.userService.GetOrCreateUser(
-		callback.From.ID,
-		callback.From.UserName,
-		callback.From.FirstName,
-		callback.From.LastName,
-	)
-	if err != nil {
-		log.Printf("Error getting user: %v", err)
-		return
-	}
-
-	h.routeCallback(callback, user)
-}
-
-func (h *BotHandlers) routeCallback(callback *tgbotapi.CallbackQuery, user *models.User) {
+func (h *BotHandlers) routeCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, user *models.User) {
 	data := callback.Data
 	chatID := callback.Message.Chat.ID
 	messageID := callback.Message.MessageID
+	defer observeCallback(data, time.Now())
+	if permission, ok := callbackPermission(data); ok && !user.HasPermission(permission) {
+		h.sendMessage(ctx, chatID, h.t("error_no_permission", user))
+		return
+	}
 	switch {
 	case strings.HasPrefix(data, "lang_"):
-		h.handleLanguageSelection(data, chatID, messageID, user)
+		h.handleLanguageSelection(ctx, data, chatID, messageID, user)
 	case data == "change_language":
-		h.handleChangeLanguage(chatID, messageID)
+		h.handleChangeLanguage(ctx, chatID, messageID)
 	case data == "check_status":
-		h.handleCheckStatus(chatID, messageID, user)
+		h.handleCheckStatus(ctx, chatID, messageID, user)
 	case data == "send_idea":
-		h.handleSendIdea(callback.From.ID, chatID, messageID, user)
+		h.handleSendIdea(ctx, callback.From.ID, chatID, messageID, user)
 	case data == "notifications":
-		h.handleNotifications(chatID, messageID, user)
+		h.handleNotifications(ctx, chatID, messageID, user)
 	case data == "notifications_toggle":
-		h.handleNotificationsToggle(chatID, messageID, user)
-	case data == "set_open_status" && user.HasRights(models.RightsManager):
-		h.handleSetOpenStatus(chatID, messageID, user)
-	case data == "set_tech_status" && user.HasRights(models.RightsManager):
-		h.handleSetTechStatus(chatID, messageID, user)
-	case data == "read_ideas" && user.HasRights(models.RightsAdmin):
-		h.handleReadIdeas(chatID, messageID, user)
-	case data == "set_rights" && user.HasRights(models.RightsAdmin):
-		h.handleSetRights(chatID, messageID, user)
-	case data == "create_broadcast" && user.HasRights(models.RightsAdmin):
-		h.handleCreateBroadcast(callback.From.ID, chatID, messageID, user)
-	case data == "auto_close" && user.HasRights(models.RightsAdmin):
-		h.handleAutoCloseSettings(chatID, messageID, user)
-	case data == "auto_close_toggle" && user.HasRights(models.RightsAdmin):
-		h.handleAutoCloseToggle(chatID, messageID, user)
-	case data == "auto_close_time" && user.HasRights(models.RightsAdmin):
-		h.handleAutoCloseTimePrompt(callback.From.ID, chatID, messageID, user)
-	case data == "auto_close_keys" && user.HasRights(models.RightsAdmin):
-		h.handleAutoCloseKeysSelect(chatID, messageID, user)
-	case strings.HasPrefix(data, "autoclose_keys_") && user.HasRights(models.RightsAdmin):
-		h.handleAutoCloseKeysUpdate(data, chatID, messageID, user)
+		h.handleNotificationsToggle(ctx, chatID, messageID, user)
+	case data == "set_open_status":
+		h.handleSetOpenStatus(ctx, chatID, messageID, user)
+	case data == "set_tech_status":
+		h.handleSetTechStatus(ctx, chatID, messageID, user)
+	case data == "read_ideas":
+		h.handleReadIdeas(ctx, chatID, messageID, user)
+	case data == "set_rights":
+		h.handleSetRights(ctx, chatID, messageID, user)
+	case data == "create_broadcast":
+		h.handleCreateBroadcast(ctx, callback.From.ID, chatID, messageID, user)
+	case data == "auto_close":
+		h.handleAutoCloseSettings(ctx, chatID, messageID, user)
+	case data == "auto_close_toggle":
+		h.handleAutoCloseToggle(ctx, chatID, messageID, user)
+	case data == "auto_close_time":
+		h.handleAutoCloseTimePrompt(ctx, callback.From.ID, chatID, messageID, user)
+	case data == "auto_close_keys":
+		h.handleAutoCloseKeysSelect(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "autoclose_keys_"):
+		h.handleAutoCloseKeysUpdate(ctx, data, chatID, messageID, user)
 	case data == "back_to_menu":
-		h.sendMainMenu(chatID, user)
+		h.sendMainMenu(ctx, chatID, user)
 		h.deleteMessage(chatID, messageID)
-	case strings.HasPrefix(data, "open_") && user.HasRights(models.RightsManager):
-		h.handleOpenStatusUpdate(data, chatID, messageID, user)
-	case strings.HasPrefix(data, "tech_") && user.HasRights(models.RightsManager):
-		h.handleTechStatusUpdate(data, chatID, messageID, user)
-	case strings.HasPrefix(data, "rights_") && user.HasRights(models.RightsAdmin):
-		h.handleRightsSelection(data, callback.From.ID, chatID, messageID, user)
-	case strings.HasPrefix(data, "idea_") && user.HasRights(models.RightsAdmin):
-		h.handleIdeaAction(data, callback, user)
-	case data == "status_logs" && user.HasRights(models.RightsAdmin):
-		h.handleStatusLogsMenu(chatID, messageID, user)
-	case data == "view_logs" && user.HasRights(models.RightsAdmin):
-		h.handleViewLogs(chatID, messageID, user, 1)
-	case data == "download_logs" && user.HasRights(models.RightsAdmin):
-		h.handleDownloadLogs(chatID, messageID, user)
-	case strings.HasPrefix(data, "logs_page_") && user.HasRights(models.RightsAdmin):
-		h.handleLogsPageNavigation(data, chatID, messageID, user)
+	case strings.HasPrefix(data, "open_"):
+		h.handleOpenStatusUpdate(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "tech_"):
+		h.handleTechStatusUpdate(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "rights_"):
+		h.handleRightsSelection(ctx, data, callback.From.ID, chatID, messageID, user)
+	case data == "roles":
+		h.handleRolesMenu(ctx, chatID, messageID, user)
+	case data == "role_new":
+		h.handleRoleCreatePrompt(ctx, callback.From.ID, chatID, messageID, user)
+	case strings.HasPrefix(data, "role_"):
+		h.handleRoleView(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "roleperm_"):
+		h.handleRolePermissionToggle(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "roledel_"):
+		h.handleRoleDelete(ctx, data, chatID, messageID, user)
+	case data == "invites":
+		h.handleInvitesMenu(ctx, chatID, messageID, user)
+	case data == "invite_new":
+		h.handleInviteRoleSelect(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "invrole_"):
+		h.handleInviteUsesSelect(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "invuses_"):
+		h.handleInviteExpirySelect(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "invexp_"):
+		h.handleInviteCreate(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "invdel_"):
+		h.handleInviteRevoke(ctx, data, chatID, messageID, user)
+	case data == "user_directory":
+		h.handleUserDirectory(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "users_"):
+		h.handleUserList(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "user_"):
+		h.handleUserProfile(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "rightsdur_"):
+		h.handleRoleDuration(ctx, data, callback.From.ID, chatID, messageID, user)
+	case strings.HasPrefix(data, "rightsconfirm_"):
+		h.handleRightsConfirm(ctx, data, chatID, messageID, user)
+	case data == "rightscancel":
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("action_cancelled", user), h.backToMenuKeyboard(user))
+	case strings.HasPrefix(data, "userrole_"):
+		h.handleUserRoleSelect(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "userroleset_"):
+		h.handleUserRoleUpdate(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "userban_"):
+		h.handleUserRestrict(ctx, data, "userban_", chatID, messageID, user)
+	case strings.HasPrefix(data, "usermute_"):
+		h.handleUserRestrict(ctx, data, "usermute_", chatID, messageID, user)
+	case strings.HasPrefix(data, "userrestrictok_"):
+		h.handleUserRestrictConfirm(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "userrestrict_"):
+		h.handleUserRestrictDuration(ctx, data, callback.From.ID, chatID, messageID, user)
+	case data == "request_access":
+		h.handleRequestAccess(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "reqrole_"):
+		h.handleRoleRequestRole(ctx, data, callback.From.ID, chatID, messageID, user)
+	case strings.HasPrefix(data, "reqok_"), strings.HasPrefix(data, "reqno_"), strings.HasPrefix(data, "reqconfirm_"):
+		h.handleRoleRequestDecision(ctx, data, chatID, messageID, user)
+	case data == "approvals":
+		h.handleApprovalQueue(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "member_"):
+		h.handleMembershipDecision(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "usermsg_"):
+		h.handleUserMessagePrompt(ctx, data, callback.From.ID, chatID, messageID, user)
+	case data == "idea_alerts":
+		h.handleIdeaAlertsSettings(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "ideaalerts_"):
+		h.handleIdeaAlertsUpdate(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "idea_"):
+		h.handleIdeaAction(ctx, data, callback, user)
+	case data == "audit_log":
+		h.handleAuditMenu(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "audit_"):
+		h.handleAuditPage(ctx, data, chatID, messageID, user)
+	case data == "status_logs":
+		h.handleStatusLogsMenu(ctx, chatID, messageID, user)
+	case data == "view_logs":
+		h.handleViewLogs(ctx, chatID, messageID, user, 1)
+	case data == "verify_logs":
+		h.handleVerifyLogs(ctx, chatID, messageID, user)
+	case data == "download_logs":
+		h.handleDownloadLogs(ctx, chatID, messageID, user)
+	case strings.HasPrefix(data, "logs_page_"):
+		h.handleLogsPageNavigation(ctx, data, chatID, messageID, user)
+	case strings.HasPrefix(data, "webhooklog_"):
+		h.handleWebhookLog(ctx, data, chatID, messageID, user)
 	default:
-		h.sendMessage(chatID, h.t("error_unknown_command", user))
+		h.sendMessage(ctx, chatID, h.t("error_unknown_command", user))
 	}
 }
 
-func (h *BotHandlers) handleUserState(message *tgbotapi.Message, state *UserState, user *models.User) {
+func (h *BotHandlers) handleUserState(ctx context.Context, message *tgbotapi.Message, state *UserState, user *models.User) {
 	chatID := message.Chat.ID
 	userID := message.From.ID
+	if permission, ok := statePermissions[state.State]; ok && !user.HasPermission(permission) {
+		h.clearUserState(userID)
+		h.sendMessage(ctx, chatID, h.t("error_no_permission", user))
+		return
+	}
 	switch state.State {
 	case "waiting_idea":
-		if len(message.Text) > 4000 {
-			h.sendMessage(chatID, h.t("idea_too_long", user))
-			return
-		}
-		if err := h.ideaService.AddIdea(userID, message.From.UserName, message.Text); err != nil {
-			h.sendMessage(chatID, h.tParams("error_save_idea", user, map[string]string{"error": err.Error()}))
-		} else {
-			h.sendMessage(chatID, h.t("idea_saved", user))
-		}
-		h.clearUserState(userID)
-		h.sendMainMenu(chatID, user)
-	case "waiting_username":
-		rightsStr, ok := state.Data["rights"].(string)
-		if !ok {
-			h.sendMessage(chatID, h.t("error_data_processing", user))
+		if user.Muted {
 			h.clearUserState(userID)
+			h.sendMessage(ctx, chatID, h.restrictionNotice("muted_notice", user))
 			return
 		}
-		rightsInt, err := strconv.Atoi(rightsStr)
-		if err != nil {
-			h.sendMessage(chatID, h.t("error_invalid_rights", user))
-			h.clearUserState(userID)
+		if message.MediaGroupID != "" {
+			h.collectAlbumPart(ctx, message, user)
 			return
 		}
-		rights := models.Rights(rightsInt)
-		if !rights.IsValid() {
-			h.sendMessage(chatID, h.t("error_invalid_rights", user))
-			h.clearUserState(userID)
-			return
+		content := message.Text
+		if content == "" {
+			content = message.Caption
 		}
-
-		username := strings.TrimSpace(message.Text)
-		if username == "" {
-			h.sendMessage(chatID, h.t("error_empty_username", user))
-			return
-		}
-
-		targetUser, err := h.userService.GetUserByUsername(username)
-		if err != nil {
-			if err == models.ErrUserNotFound {
-				h.sendMessage(chatID, h.t("user_not_found", user))
-			} else {
-				h.sendMessage(chatID, h.tParams("error_find_user", user, map[string]string{"error": err.Error()}))
-			}
-			h.clearUserState(userID)
-			return
-		}
-
-		if err := h.userService.UpdateUserRightsByUsername(username, rights); err != nil {
-			h.sendMessage(chatID, h.tParams("error_update_rights", user, map[string]string{"error": err.Error()}))
-		} else {
-			h.sendMessage(chatID, h.tParams("rights_updated", user, map[string]string{
-				"user":   targetUser.GetDisplayName(),
-				"rights": h.getRightsName(rights, user),
-			}))
-		}
-		h.clearUserState(userID)
-		h.sendMainMenu(chatID, user)
+		h.submitIdea(ctx, chatID, message.From, user, content, ideaAttachmentsFromMessage(message))
+	case "waiting_username":
+		h.handleRightsTarget(ctx, message, state, user)
 	case "waiting_broadcast":
-		if len(message.Text) > 4000 {
-			h.sendMessage(chatID, h.t("idea_too_long", user))
+		sentCount, err := h.SendBroadcast(ctx, user, message.Text)
+		if err == models.ErrBroadcastTooLong {
+			h.sendMessage(ctx, chatID, h.t("idea_too_long", user))
 			return
 		}
-		sentCount, err := h.broadcastService.SendBroadcast(message.Text)
 		if err != nil {
-			h.sendMessage(chatID, h.tParams("error_broadcast", user, map[string]string{"error": err.Error()}))
+			h.sendMessage(ctx, chatID, h.tParams("error_broadcast", user, map[string]string{"error": err.Error()}))
 		} else {
-			h.sendMessage(chatID, h.tParams("broadcast_sent", user, map[string]string{"count": strconv.Itoa(sentCount)}))
+			h.sendMessage(ctx, chatID, h.tParams("broadcast_sent", user, map[string]string{"count": strconv.Itoa(sentCount)}))
 		}
 		h.clearUserState(userID)
-		h.sendMainMenu(chatID, user)
+		h.sendMainMenu(ctx, chatID, user)
 	case "waiting_auto_close_time":
 		timeStr := strings.TrimSpace(message.Text)
 		h.clearUserState(userID)
-		h.handleAutoCloseTimeUpdate(chatID, user, timeStr)
+		h.handleAutoCloseTimeUpdate(ctx, chatID, user, timeStr)
+	case "waiting_role_expiry":
+		h.handleRoleExpiryInput(ctx, message, state, user)
+	case "waiting_role_name":
+		h.clearUserState(userID)
+		h.handleRoleCreate(ctx, chatID, user, message.Text)
+	case "waiting_role_request_reason":
+		h.handleRoleRequestReason(ctx, message, state, user)
+	case "waiting_restriction_reason":
+		h.handleRestrictionReason(ctx, message, state, user)
+	case "waiting_user_message":
+		if len(message.Text) > 4000 {
+			h.sendMessage(ctx, chatID, h.t("idea_too_long", user))
+			return
+		}
+		h.clearUserState(userID)
+		h.handleUserMessageSend(ctx, chatID, user, state, message.Text)
+		h.sendMainMenu(ctx, chatID, user)
 	}
 }
 
-func (h *BotHandlers) getRightsName(rights models.Rights, user *models.User) string {
-	lang := h.getUserLang(user)
-	switch rights {
-	case models.RightsDefault:
-		return h.translator.Get("rights_user", lang)
-	case models.RightsManager:
-		return h.translator.Get("rights_manager", lang)
-	case models.RightsAdmin:
-		return h.translator.Get("rights_admin", lang)
-	default:
-		return h.translator.Get("rights_unknown", lang)
+// submitIdea saves an idea and tells the user and the idea managers about
+// it. An idea that is too long keeps the user's state, so that a shorter
+// version can be sent right away.
+func (h *BotHandlers) submitIdea(ctx context.Context, chatID int64, from *tgbotapi.User, user *models.User, content string, attachments []models.IdeaAttachment) {
+	userID := from.ID
+	idea, err := h.ideaService.AddIdea(ctx, userID, from.UserName, content, attachments)
+	if err != nil {
+		switch err {
+		case models.ErrIdeaRateLimited:
+			h.sendMessage(ctx, chatID, h.t("idea_rate_limited", user))
+		case models.ErrIdeaDuplicate:
+			h.sendMessage(ctx, chatID, h.t("idea_duplicate", user))
+		case models.ErrIdeaBannedWords:
+			h.sendMessage(ctx, chatID, h.t("idea_banned_words", user))
+		case models.ErrIdeaTooLong:
+			h.sendMessage(ctx, chatID, h.t("idea_too_long", user))
+		default:
+			h.sendMessage(ctx, chatID, h.tParams("error_save_idea", user, map[string]string{"error": err.Error()}))
+		}
+		if h.ideaService.ShouldReportOffender(userID) {
+			h.notifyAdmins(ctx, models.PermIdeasManage, "idea_offender_alert", map[string]string{
+				"user": user.GetDisplayName(),
+				"id":   strconv.FormatInt(userID, 10),
+			})
+		}
+		// A shorter version can be sent right away.
+		if err == models.ErrIdeaTooLong {
+			return
+		}
+	} else {
+		h.sendMessage(ctx, chatID, h.t("idea_saved", user))
+		h.goBackground(func() { h.notifyNewIdea(ctx, idea) })
+		h.webhookService.Emit(ctx, models.WebhookIdeaCreated, newIdeaWebhookData(idea))
 	}
+	h.clearUserState(userID)
+	h.sendMainMenu(ctx, chatID, user)
+}
+
+func ideaAttachmentsFromMessage(message *tgbotapi.Message) []models.IdeaAttachment {
+	var attachments []models.IdeaAttachment
+	if len(message.Photo) > 0 {
+		largest := message.Photo[len(message.Photo)-1]
+		attachments = append(attachments, models.IdeaAttachment{
+			FileID: largest.FileID,
+			Type:   models.AttachmentPhoto,
+		})
+	}
+	if message.Document != nil {
+		attachments = append(attachments, models.IdeaAttachment{
+			FileID:   message.Document.FileID,
+			Type:     models.AttachmentDocument,
+			FileName: message.Document.FileName,
+		})
+	}
+	return attachments
+}
+
+func (h *BotHandlers) getRoleName(user *models.User) string {
+	if user.Role != nil {
+		return h.menu.RoleName(user.Role, h.getUserLang(user))
+	}
+	return h.t("rights_"+models.BuiltInRoleForRights(user.Rights), user)
 }
 
 func (h *BotHandlers) setUserState(userID int64, state string, data map[string]interface{}) {
@@ -314,7 +479,7 @@ func (h *BotHandlers) setUserState(userID int64, state string, data map[string]i
 	h.userStates[userID] = &UserState{
 		State:   state,
 		Data:    data,
-		Expires: time.Now().Add(10 * time.Minute),
+		Expires: time.Now().Add(h.stateTTL),
 	}
 }
 
@@ -336,9 +501,14 @@ func (h *BotHandlers) clearUserState(userID int64) {
 }
 
 func (h *BotHandlers) cleanupExpiredStates() {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(h.stateTTL / 2)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
 		h.stateMutex.Lock()
 		now := time.Now()
 		for userID, state := range h.userStates {
@@ -350,75 +520,73 @@ func (h *BotHandlers) cleanupExpiredStates() {
 	}
 }
 
-func (h *BotHandlers) sendLanguageSelection(chatID int64) {
+func (h *BotHandlers) sendLanguageSelection(ctx context.Context, chatID int64) {
 	keyboard := h.menu.GenerateLanguageKeyboard()
 	msg := tgbotapi.NewMessage(chatID, "🌍 Оберіть мову / Select language:")
 	msg.ReplyMarkup = keyboard
 	if _, err := h.bot.Send(msg); err != nil {
-		log.Printf("Error sending language selection: %v", err)
+		slog.ErrorContext(ctx, "Error sending language selection", "error", err)
 	}
 }
 
-func (h *BotHandlers) handleLanguageSelection(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleLanguageSelection(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	langCode := strings.TrimPrefix(data, "lang_")
 	lang := i18n.ParseLanguage(langCode)
 
 	if err := h.userService.UpdateUserLanguage(user.TelegramID, lang.String()); err != nil {
-		log.Printf("Error updating user language: %v", err)
+		slog.ErrorContext(ctx, "Error updating user language", "error", err)
 	}
 
 	user.Language = lang.String()
 
 	h.deleteMessage(chatID, messageID)
-	h.sendWelcomeMessage(chatID, user)
+	h.sendWelcomeMessage(ctx, chatID, user)
 }
 
-func (h *BotHandlers) handleChangeLanguage(chatID int64, messageID int) {
+func (h *BotHandlers) handleChangeLanguage(ctx context.Context, chatID int64, messageID int) {
 	keyboard := h.menu.GenerateLanguageKeyboard()
-	h.editMessageWithKeyboard(chatID, messageID, "🌍 Оберіть мову / Select language:", keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, "🌍 Оберіть мову / Select language:", keyboard)
 }
 
-func (h *BotHandlers) sendWelcomeMessage(chatID int64, user *models.User) {
+func (h *BotHandlers) sendWelcomeMessage(ctx context.Context, chatID int64, user *models.User) {
 	welcomeText := h.tParams("welcome", user, map[string]string{
 		"display_name": user.GetDisplayName(),
-		"rights":       h.getRightsName(user.Rights, user),
+		"rights":       h.getRoleName(user),
 	})
-	h.sendMessage(chatID, welcomeText)
-	h.sendMainMenu(chatID, user)
+	h.sendMessage(ctx, chatID, welcomeText)
+	h.sendMainMenu(ctx, chatID, user)
 }
 
-func (h *BotHandlers) sendHelpMessage(chatID int64, user *models.User) {
+func (h *BotHandlers) sendHelpMessage(ctx context.Context, chatID int64, user *models.User) {
 	helpText := h.t("help_header", user)
 	helpText += h.t("help_commands", user)
 
-	if user.HasRights(models.RightsDefault) {
-		helpText += h.t("help_user_features", user)
-	}
+	helpText += h.t("help_user_features", user)
 
-	if user.HasRights(models.RightsManager) {
+	if user.HasPermission(models.PermStatusChange) || user.HasPermission(models.PermKeysChange) {
 		helpText += h.t("help_manager_features", user)
 	}
 
-	if user.HasRights(models.RightsAdmin) {
+	if user.HasPermission(models.PermRightsManage) {
 		helpText += h.t("help_admin_features", user)
 	}
-	h.sendMessage(chatID, helpText)
+	h.sendMessage(ctx, chatID, helpText)
 }
 
-func (h *BotHandlers) sendMainMenu(chatID int64, user *models.User) {
+func (h *BotHandlers) sendMainMenu(ctx context.Context, chatID int64, user *models.User) {
 	lang := h.getUserLang(user)
-	keyboard := h.menu.GenerateKeyboard(user.Rights, lang)
+	keyboard := h.menu.GenerateKeyboard(user, lang)
 	msg := tgbotapi.NewMessage(chatID, h.t("main_menu", user))
 	msg.ReplyMarkup = keyboard
 	if _, err := h.bot.Send(msg); err != nil {
-		log.Printf("Error sending main menu: %v", err)
+		slog.ErrorContext(ctx, "Error sending main menu", "error", err)
 	}
 }
 
-func (h *BotHandlers) handleCheckStatus(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleCheckStatus(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	status, err := h.statusService.GetStatus()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_get_status", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_get_status", user))
 		return
 	}
 	statusText := h.formatStatus(status, user)
@@ -429,7 +597,7 @@ func (h *BotHandlers) handleCheckStatus(chatID int64, messageID int, user *model
 			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
 		),
 	)
-	h.editMessageWithKeyboard(chatID, messageID, statusText, keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, statusText, keyboard)
 }
 
 func (h *BotHandlers) formatStatus(status *models.Status, user *models.User) string {
@@ -445,15 +613,15 @@ func (h *BotHandlers) formatStatus(status *models.Status, user *models.User) str
 	text := h.t("status_header", user)
 	text += h.tParams("status_state", user, map[string]string{"status": openStatus}) + "\n"
 	text += h.tParams("status_keys", user, map[string]string{"location": keyLocation}) + "\n"
-	text += h.tParams("status_updated", user, map[string]string{"time": status.UpdatedAt.Format("02.01.2006 15:04")})
+	text += h.tParams("status_updated", user, map[string]string{"time": h.localTime(status.UpdatedAt).Format("02.01.2006 15:04")})
 
-	if user.Rights >= models.RightsManager {
+	if user.HasPermission(models.PermStatusChange) || user.HasPermission(models.PermKeysChange) {
 		text += "\n" + h.tParams("status_updated_by", user, map[string]string{"user": status.UpdatedBy})
 	}
 	return text
 }
 
-func (h *BotHandlers) handleNotifications(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleNotifications(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	var statusText string
 	if user.NotificationsEnabled {
 		statusText = h.t("notifications_enabled", user)
@@ -463,115 +631,100 @@ func (h *BotHandlers) handleNotifications(chatID int64, messageID int, user *mod
 
 	lang := h.getUserLang(user)
 	keyboard := h.menu.GenerateNotificationsKeyboard(user.NotificationsEnabled, lang)
-	h.editMessageWithKeyboard(chatID, messageID, statusText, keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, statusText, keyboard)
 }
 
-func (h *BotHandlers) handleNotificationsToggle(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleNotificationsToggle(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	newStatus := !user.NotificationsEnabled
 
 	if err := h.userService.UpdateNotificationsEnabled(user.TelegramID, newStatus); err != nil {
-		h.editMessage(chatID, messageID, h.t("error_notifications", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_notifications", user))
 		return
 	}
 
 	user.NotificationsEnabled = newStatus
-	h.handleNotifications(chatID, messageID, user)
+	h.handleNotifications(ctx, chatID, messageID, user)
 }
 
-func (h *BotHandlers) handleSendIdea(userID, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleSendIdea(ctx context.Context, userID, chatID int64, messageID int, user *models.User) {
+	if user.Muted {
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.restrictionNotice("muted_notice", user), h.backToMenuKeyboard(user))
+		return
+	}
 	h.setUserState(userID, "waiting_idea", nil)
-	h.editMessage(chatID, messageID, h.t("idea_prompt", user))
+	h.editMessage(ctx, chatID, messageID, h.t("idea_prompt", user))
 }
 
-func (h *BotHandlers) handleSetOpenStatus(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleSetOpenStatus(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	lang := h.getUserLang(user)
 	keyboard := h.menu.GenerateStatusKeyboard("open", lang)
-	h.editMessageWithKeyboard(chatID, messageID, h.t("status_select_open", user), keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("status_select_open", user), keyboard)
 }
 
-func (h *BotHandlers) handleSetTechStatus(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleSetTechStatus(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	lang := h.getUserLang(user)
 	keyboard := h.menu.GenerateStatusKeyboard("tech", lang)
-	h.editMessageWithKeyboard(chatID, messageID, h.t("status_select_keys", user), keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("status_select_keys", user), keyboard)
 }
 
-func (h *BotHandlers) handleOpenStatusUpdate(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleOpenStatusUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	isOpen := strings.TrimPrefix(data, "open_") == "true"
 
-	if err := h.statusService.UpdateOpenStatus(isOpen, user); err != nil {
-		h.editMessage(chatID, messageID, h.t("error_update_status", user))
+	if err := h.SetOpenStatus(ctx, user, isOpen); err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_update_status", user))
 		return
 	}
 
-	if err := h.logService.LogStatusChange(isOpen, user.GetDisplayName()); err != nil {
-		log.Printf("Error logging status change: %v", err)
-	}
-
-	h.schedulerService.UpdateLastUser(user.GetDisplayName())
-
 	statusText := h.t("status_changed_closed", user)
 	if isOpen {
 		statusText = h.t("status_changed_open", user)
-		go func() {
-			if count, err := h.broadcastService.SendOpenNotification(); err != nil {
-				log.Printf("Error sending open notifications: %v", err)
-			} else {
-				log.Printf("Open notifications sent to %d users", count)
-			}
-		}()
 	}
 
-	h.editMessage(chatID, messageID, statusText)
+	h.editMessage(ctx, chatID, messageID, statusText)
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		h.sendMainMenu(chatID, user)
+	h.later(func() {
+		h.sendMainMenu(ctx, chatID, user)
 		h.deleteMessage(chatID, messageID)
-	}()
+	})
 }
 
-func (h *BotHandlers) handleTechStatusUpdate(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleTechStatusUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	techStatus := strings.TrimPrefix(data, "tech_") == "true"
 
-	if err := h.statusService.UpdateTechnicalStatus(techStatus, user); err != nil {
-		h.editMessage(chatID, messageID, h.t("error_update_keys", user))
+	if err := h.SetKeysLocation(ctx, user, techStatus); err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_update_keys", user))
 		return
 	}
 
-	if err := h.logService.LogKeysChange(techStatus, user.GetDisplayName()); err != nil {
-		log.Printf("Error logging keys change: %v", err)
-	}
-
 	location := h.t("keys_location_lobby", user)
 	if techStatus {
 		location = h.t("keys_location_admin", user)
 	}
-	h.editMessage(chatID, messageID, h.tParams("status_keys_changed", user, map[string]string{"location": location}))
+	h.editMessage(ctx, chatID, messageID, h.tParams("status_keys_changed", user, map[string]string{"location": location}))
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		h.sendMainMenu(chatID, user)
+	h.later(func() {
+		h.sendMainMenu(ctx, chatID, user)
 		h.deleteMessage(chatID, messageID)
-	}()
+	})
 }
 
-func (h *BotHandlers) handleReadIdeas(chatID int64, messageID int, user *models.User) {
-	ideas, err := h.ideaService.GetAllIdeas()
+func (h *BotHandlers) handleReadIdeas(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	ideas, err := h.ideaService.GetActiveIdeas()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_get_ideas", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_get_ideas", user))
 		return
 	}
 	if len(ideas) == 0 {
-		h.editMessage(chatID, messageID, h.t("ideas_empty", user))
-		time.Sleep(2 * time.Second)
-		h.sendMainMenu(chatID, user)
+		h.editMessage(ctx, chatID, messageID, h.t("ideas_empty", user))
+		h.pause()
+		h.sendMainMenu(ctx, chatID, user)
 		h.deleteMessage(chatID, messageID)
 		return
 	}
-	h.showIdea(chatID, messageID, ideas, 0, user)
+	h.showIdea(ctx, chatID, messageID, ideas, 0, user)
 }
 
-func (h *BotHandlers) showIdea(chatID int64, messageID int, ideas []models.Idea, currentIndex int, user *models.User) {
+func (h *BotHandlers) showIdea(ctx context.Context, chatID int64, messageID int, ideas []models.Idea, currentIndex int, user *models.User) {
 	if currentIndex < 0 || currentIndex >= len(ideas) {
 		return
 	}
@@ -585,14 +738,22 @@ func (h *BotHandlers) showIdea(chatID int64, messageID int, ideas []models.Idea,
 		"total":    strconv.Itoa(len(ideas)),
 		"id":       strconv.FormatInt(idea.ID, 10),
 		"username": username,
-		"date":     idea.CreatedAt.Format("02.01.2006 15:04"),
+		"date":     h.localTime(idea.CreatedAt).Format("02.01.2006 15:04"),
 		"content":  idea.Content,
 	})
-	keyboard := h.generateIdeaKeyboard(currentIndex, len(ideas), idea.ID, user)
-	h.editMessageWithKeyboard(chatID, messageID, text, keyboard)
+	text += "\n\n" + h.tParams("idea_status_line", user, map[string]string{"status": h.getIdeaStatusName(idea.Status, user)})
+	attachments, err := h.ideaService.GetIdeaAttachments(idea.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting idea attachments", "idea_id", idea.ID, "error", err)
+	}
+	if len(attachments) > 0 {
+		text += "\n\n" + h.tParams("idea_attachments", user, map[string]string{"count": strconv.Itoa(len(attachments))})
+	}
+	keyboard := h.generateIdeaKeyboard(currentIndex, len(ideas), idea.ID, len(attachments), user)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
 }
 
-func (h *BotHandlers) generateIdeaKeyboard(currentIndex, totalIdeas int, ideaID int64, user *models.User) tgbotapi.InlineKeyboardMarkup {
+func (h *BotHandlers) generateIdeaKeyboard(currentIndex, totalIdeas int, ideaID int64, attachmentCount int, user *models.User) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	var navRow []tgbotapi.InlineKeyboardButton
 	if currentIndex > 0 {
@@ -604,6 +765,15 @@ func (h *BotHandlers) generateIdeaKeyboard(currentIndex, totalIdeas int, ideaID
 	if len(navRow) > 0 {
 		rows = append(rows, navRow)
 	}
+	if attachmentCount > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_attachments", user), fmt.Sprintf("idea_files_%d", ideaID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_status", user), fmt.Sprintf("idea_status_%d", ideaID)),
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_archive", user), fmt.Sprintf("idea_archive_%d", ideaID)),
+	))
 	actionRow := []tgbotapi.InlineKeyboardButton{
 		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_delete_idea", user), fmt.Sprintf("idea_delete_%d", ideaID)),
 	}
@@ -615,20 +785,68 @@ func (h *BotHandlers) generateIdeaKeyboard(currentIndex, totalIdeas int, ideaID
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func (h *BotHandlers) handleIdeaAction(data string, callback *tgbotapi.CallbackQuery, user *models.User) {
+func (h *BotHandlers) handleIdeaAction(ctx context.Context, data string, callback *tgbotapi.CallbackQuery, user *models.User) {
 	chatID := callback.Message.Chat.ID
 	messageID := callback.Message.MessageID
 	if strings.HasPrefix(data, "idea_prev_") || strings.HasPrefix(data, "idea_next_") {
-		h.handleIdeaNavigation(data, chatID, messageID, user)
+		h.handleIdeaNavigation(ctx, data, chatID, messageID, user)
 	} else if strings.HasPrefix(data, "idea_delete_") {
-		h.handleIdeaDelete(data, chatID, messageID, user)
+		h.handleIdeaDelete(ctx, data, chatID, messageID, user)
+	} else if strings.HasPrefix(data, "idea_files_") {
+		h.handleIdeaAttachments(ctx, data, chatID, user)
+	} else if strings.HasPrefix(data, "idea_open_") {
+		h.handleIdeaOpen(ctx, data, chatID, messageID, user)
+	} else if strings.HasPrefix(data, "idea_status_") {
+		h.handleIdeaStatusSelect(ctx, data, chatID, messageID, user)
+	} else if strings.HasPrefix(data, "idea_setstatus_") {
+		h.handleIdeaStatusUpdate(ctx, data, chatID, messageID, user)
+	} else if strings.HasPrefix(data, "idea_archive_") {
+		h.handleIdeaArchive(ctx, data, chatID, messageID, user)
+	}
+}
+
+func (h *BotHandlers) handleIdeaAttachments(ctx context.Context, data string, chatID int64, user *models.User) {
+	ideaID, err := strconv.ParseInt(strings.TrimPrefix(data, "idea_files_"), 10, 64)
+	if err != nil {
+		h.sendMessage(ctx, chatID, h.t("error_idea_id", user))
+		return
+	}
+	if _, err := h.ideaService.GetIdeaByID(ideaID); err != nil {
+		if err == models.ErrIdeaNotFound {
+			h.sendMessage(ctx, chatID, h.t("error_idea_id", user))
+		} else {
+			h.sendMessage(ctx, chatID, h.t("error_get_ideas", user))
+		}
+		return
+	}
+	attachments, err := h.ideaService.GetIdeaAttachments(ideaID)
+	if err != nil {
+		h.sendMessage(ctx, chatID, h.t("error_get_ideas", user))
+		return
+	}
+	caption := h.tParams("idea_attachment_caption", user, map[string]string{"id": strconv.FormatInt(ideaID, 10)})
+	for _, attachment := range attachments {
+		var msg tgbotapi.Chattable
+		switch attachment.Type {
+		case models.AttachmentPhoto:
+			photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(attachment.FileID))
+			photo.Caption = caption
+			msg = photo
+		default:
+			doc := tgbotapi.NewDocument(chatID, tgbotapi.FileID(attachment.FileID))
+			doc.Caption = caption
+			msg = doc
+		}
+		if _, err := h.bot.Send(msg); err != nil {
+			slog.WarnContext(ctx, "Error sending idea attachment", "attachment_id", attachment.ID, "idea_id", ideaID, "error", err)
+		}
 	}
 }
 
-func (h *BotHandlers) handleIdeaNavigation(data string, chatID int64, messageID int, user *models.User) {
-	ideas, err := h.ideaService.GetAllIdeas()
+func (h *BotHandlers) handleIdeaNavigation(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	ideas, err := h.ideaService.GetActiveIdeas()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_get_ideas", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_get_ideas", user))
 		return
 	}
 	var newIndex int
@@ -639,73 +857,126 @@ func (h *BotHandlers) handleIdeaNavigation(data string, chatID int64, messageID
 		currentIndex, _ := strconv.Atoi(strings.TrimPrefix(data, "idea_next_"))
 		newIndex = currentIndex + 1
 	}
-	h.showIdea(chatID, messageID, ideas, newIndex, user)
+	h.showIdea(ctx, chatID, messageID, ideas, newIndex, user)
 }
 
-func (h *BotHandlers) handleIdeaDelete(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleIdeaDelete(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	ideaIDStr := strings.TrimPrefix(data, "idea_delete_")
 	ideaID, err := strconv.ParseInt(ideaIDStr, 10, 64)
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_idea_id", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_idea_id", user))
 		return
 	}
-	if err := h.ideaService.DeleteIdea(ideaID); err != nil {
+	if err := h.DeleteIdea(ctx, user, ideaID); err != nil {
 		if err == models.ErrIdeaNotFound {
-			h.editMessage(chatID, messageID, h.t("idea_not_found", user))
+			h.editMessage(ctx, chatID, messageID, h.t("idea_not_found", user))
 		} else {
-			h.editMessage(chatID, messageID, h.t("error_delete_idea", user))
+			h.editMessage(ctx, chatID, messageID, h.t("error_delete_idea", user))
 		}
 		return
 	}
-	h.editMessage(chatID, messageID, h.t("idea_deleted", user))
+	h.editMessage(ctx, chatID, messageID, h.t("idea_deleted", user))
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		h.sendMainMenu(chatID, user)
+	h.later(func() {
+		h.sendMainMenu(ctx, chatID, user)
 		h.deleteMessage(chatID, messageID)
-	}()
+	})
 }
 
-func (h *BotHandlers) handleSetRights(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleSetRights(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
 	lang := h.getUserLang(user)
-	keyboard := h.menu.GenerateRightsKeyboard(lang)
-	h.editMessageWithKeyboard(chatID, messageID, h.t("rights_select", user), keyboard)
+	keyboard := h.menu.GenerateRightsKeyboard(roles, lang)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("rights_select", user), keyboard)
+}
+
+func (h *BotHandlers) handleRightsSelection(ctx context.Context, data string, userID, chatID int64, messageID int, user *models.User) {
+	roleID, err := strconv.ParseInt(strings.TrimPrefix(data, "rights_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_invalid_rights", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_invalid_rights", user))
+		return
+	}
+
+	if role.BuiltIn && role.Name == models.RoleUser {
+		h.promptRightsTarget(ctx, userID, chatID, messageID, role.ID, time.Time{}, user)
+		return
+	}
+	h.showRoleDurations(ctx, chatID, messageID, role, user)
 }
 
-func (h *BotHandlers) handleRightsSelection(data string, userID, chatID int64, messageID int, user *models.User) {
-	rightsStr := strings.TrimPrefix(data, "rights_")
+// promptRightsTarget asks who should get the role. A zero expiresAt grants
+// the role permanently.
+func (h *BotHandlers) promptRightsTarget(ctx context.Context, userID, chatID int64, messageID int, roleID int64, expiresAt time.Time, user *models.User) {
 	h.setUserState(userID, "waiting_username", map[string]interface{}{
-		"rights": rightsStr,
+		"role_id":    roleID,
+		"expires_at": expiresAt,
 	})
-	h.editMessage(chatID, messageID, h.t("rights_username_prompt", user))
+	if messageID != 0 {
+		h.deleteMessage(chatID, messageID)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, h.t("rights_username_prompt", user))
+	msg.ReplyMarkup = h.targetPickerKeyboard(user)
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending rights prompt", "error", err)
+	}
 }
 
-func (h *BotHandlers) handleCreateBroadcast(userID, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleCreateBroadcast(ctx context.Context, userID, chatID int64, messageID int, user *models.User) {
 	h.setUserState(userID, "waiting_broadcast", nil)
-	h.editMessage(chatID, messageID, h.t("broadcast_prompt", user))
+	h.editMessage(ctx, chatID, messageID, h.t("broadcast_prompt", user))
+}
+
+func (h *BotHandlers) notifyAdmins(ctx context.Context, permission models.Permission, key string, params map[string]string) {
+	admins, err := h.userService.GetUsersWithPermission(permission)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting admins for notification", "error", err)
+		return
+	}
+	for _, admin := range admins {
+		h.sendMessage(ctx, admin.TelegramID, h.tParams(key, &admin, params))
+	}
 }
 
-func (h *BotHandlers) sendMessage(chatID int64, text string) {
+func (h *BotHandlers) backToMenuKeyboard(user *models.User) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+		),
+	)
+}
+
+func (h *BotHandlers) sendMessage(ctx context.Context, chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	if _, err := h.bot.Send(msg); err != nil {
-		log.Printf("Error sending message: %v", err)
+		slog.WarnContext(ctx, "Error sending message", "error", err)
 	}
 }
 
-func (h *BotHandlers) editMessage(chatID int64, messageID int, text string) {
+func (h *BotHandlers) editMessage(ctx context.Context, chatID int64, messageID int, text string) {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
 	if _, err := h.bot.Send(edit); err != nil {
 		if !strings.Contains(err.Error(), "message is not modified") {
-			log.Printf("Error editing message: %v", err)
+			slog.WarnContext(ctx, "Error editing message", "error", err)
 		}
 	}
 }
 
-func (h *BotHandlers) editMessageWithKeyboard(chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+func (h *BotHandlers) editMessageWithKeyboard(ctx context.Context, chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
 	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard)
 	if _, err := h.bot.Send(edit); err != nil {
 		if !strings.Contains(err.Error(), "message is not modified") {
-			log.Printf("Error editing message with keyboard: %v", err)
+			slog.WarnContext(ctx, "Error editing message with keyboard", "error", err)
 		}
 	}
 }
@@ -729,10 +1000,10 @@ func (h *BotHandlers) answerCallback(callbackID, text string) {
 	h.bot.Request(callback)
 }
 
-func (h *BotHandlers) handleAutoCloseSettings(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleAutoCloseSettings(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	settings, err := h.schedulerService.GetSettings()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
@@ -771,31 +1042,35 @@ func (h *BotHandlers) handleAutoCloseSettings(chatID int64, messageID int, user
 		),
 	)
 
-	h.editMessageWithKeyboard(chatID, messageID, text, keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
 }
 
-func (h *BotHandlers) handleAutoCloseToggle(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleAutoCloseToggle(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	settings, err := h.schedulerService.GetSettings()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
 	newEnabled := !settings.Enabled
-	if err := h.schedulerService.UpdateSettings(newEnabled, settings.CloseTime, settings.KeysToLobby); err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+	if err := h.schedulerService.UpdateSettings(ctx, newEnabled, settings.CloseTime, settings.KeysToLobby); err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
-	h.handleAutoCloseSettings(chatID, messageID, user)
+	before := autoCloseSummary(settings)
+	settings.Enabled = newEnabled
+	h.audit(ctx, user, models.AuditAutoCloseUpdate, "auto-close", before, autoCloseSummary(settings))
+
+	h.handleAutoCloseSettings(ctx, chatID, messageID, user)
 }
 
-func (h *BotHandlers) handleAutoCloseTimePrompt(userID, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleAutoCloseTimePrompt(ctx context.Context, userID, chatID int64, messageID int, user *models.User) {
 	h.setUserState(userID, "waiting_auto_close_time", nil)
-	h.editMessage(chatID, messageID, h.t("auto_close_time_prompt", user))
+	h.editMessage(ctx, chatID, messageID, h.t("auto_close_time_prompt", user))
 }
 
-func (h *BotHandlers) handleAutoCloseKeysSelect(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleAutoCloseKeysSelect(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(h.t("status_btn_keys_lobby", user), "autoclose_keys_lobby"),
@@ -805,55 +1080,62 @@ func (h *BotHandlers) handleAutoCloseKeysSelect(chatID int64, messageID int, use
 			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "auto_close"),
 		),
 	)
-	h.editMessageWithKeyboard(chatID, messageID, h.t("auto_close_keys_select", user), keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("auto_close_keys_select", user), keyboard)
 }
 
-func (h *BotHandlers) handleAutoCloseKeysUpdate(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleAutoCloseKeysUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	keysToLobby := strings.TrimPrefix(data, "autoclose_keys_") == "lobby"
 
 	settings, err := h.schedulerService.GetSettings()
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
-	if err := h.schedulerService.UpdateSettings(settings.Enabled, settings.CloseTime, keysToLobby); err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+	if err := h.schedulerService.UpdateSettings(ctx, settings.Enabled, settings.CloseTime, keysToLobby); err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
+	before := autoCloseSummary(settings)
+	settings.KeysToLobby = keysToLobby
+	h.audit(ctx, user, models.AuditAutoCloseUpdate, "auto-close", before, autoCloseSummary(settings))
+
 	location := h.t("keys_location_lobby", user)
 	if !keysToLobby {
 		location = h.t("keys_location_admin", user)
 	}
 
-	h.editMessage(chatID, messageID, h.tParams("auto_close_keys_updated", user, map[string]string{"location": location}))
+	h.editMessage(ctx, chatID, messageID, h.tParams("auto_close_keys_updated", user, map[string]string{"location": location}))
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		h.handleAutoCloseSettings(chatID, messageID, user)
-	}()
+	h.later(func() {
+		h.handleAutoCloseSettings(ctx, chatID, messageID, user)
+	})
 }
 
-func (h *BotHandlers) handleAutoCloseTimeUpdate(chatID int64, user *models.User, timeStr string) {
+func (h *BotHandlers) handleAutoCloseTimeUpdate(ctx context.Context, chatID int64, user *models.User, timeStr string) {
 	if !h.isValidTimeFormat(timeStr) {
-		h.sendMessage(chatID, h.t("auto_close_time_invalid", user))
+		h.sendMessage(ctx, chatID, h.t("auto_close_time_invalid", user))
 		return
 	}
 
 	settings, err := h.schedulerService.GetSettings()
 	if err != nil {
-		h.sendMessage(chatID, h.t("error_generic", user))
+		h.sendMessage(ctx, chatID, h.t("error_generic", user))
 		return
 	}
 
-	if err := h.schedulerService.UpdateSettings(settings.Enabled, timeStr, settings.KeysToLobby); err != nil {
-		h.sendMessage(chatID, h.t("error_generic", user))
+	if err := h.schedulerService.UpdateSettings(ctx, settings.Enabled, timeStr, settings.KeysToLobby); err != nil {
+		h.sendMessage(ctx, chatID, h.t("error_generic", user))
 		return
 	}
 
-	h.sendMessage(chatID, h.tParams("auto_close_time_updated", user, map[string]string{"time": timeStr}))
-	h.sendMainMenu(chatID, user)
+	before := autoCloseSummary(settings)
+	settings.CloseTime = timeStr
+	h.audit(ctx, user, models.AuditAutoCloseUpdate, "auto-close", before, autoCloseSummary(settings))
+
+	h.sendMessage(ctx, chatID, h.tParams("auto_close_time_updated", user, map[string]string{"time": timeStr}))
+	h.sendMainMenu(ctx, chatID, user)
 }
 
 func (h *BotHandlers) isValidTimeFormat(timeStr string) bool {
@@ -875,7 +1157,7 @@ func (h *BotHandlers) isValidTimeFormat(timeStr string) bool {
 	return true
 }
 
-func (h *BotHandlers) handleStatusLogsMenu(chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleStatusLogsMenu(ctx context.Context, chatID int64, messageID int, user *models.User) {
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_view_logs", user), "view_logs"),
@@ -883,22 +1165,28 @@ func (h *BotHandlers) handleStatusLogsMenu(chatID int64, messageID int, user *mo
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_download_logs", user), "download_logs"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_verify_logs", user), "verify_logs"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_webhook_log", user), "webhooklog_1"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
 		),
 	)
 
-	h.editMessageWithKeyboard(chatID, messageID, h.t("logs_menu_header", user), keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("logs_menu_header", user), keyboard)
 }
 
-func (h *BotHandlers) handleViewLogs(chatID int64, messageID int, user *models.User, page int) {
-	now := time.Now()
+func (h *BotHandlers) handleViewLogs(ctx context.Context, chatID int64, messageID int, user *models.User, page int) {
+	now := h.localTime(time.Now())
 	month := int(now.Month())
 	year := now.Year()
 
 	entries, totalPages, err := h.logService.GetLogEntries(month, year, page)
 	if err != nil {
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
@@ -908,7 +1196,7 @@ func (h *BotHandlers) handleViewLogs(chatID int64, messageID int, user *models.U
 				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "status_logs"),
 			),
 		)
-		h.editMessageWithKeyboard(chatID, messageID, h.t("logs_empty", user), keyboard)
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("logs_empty", user), keyboard)
 		return
 	}
 
@@ -936,15 +1224,15 @@ func (h *BotHandlers) handleViewLogs(chatID int64, messageID int, user *models.U
 		}
 
 		text += h.tParams("logs_entry", user, map[string]string{
-			"date":   entry.Timestamp.Format("02.01.2006"),
-			"time":   entry.Timestamp.Format("15:04:05"),
+			"date":   h.localTime(entry.Timestamp).Format("02.01.2006"),
+			"time":   h.localTime(entry.Timestamp).Format("15:04:05"),
 			"status": actionText,
 			"user":   entry.ChangedBy,
 		})
 	}
 
 	keyboard := h.generateLogsKeyboard(page, totalPages, user)
-	h.editMessageWithKeyboard(chatID, messageID, text, keyboard)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
 }
 
 func (h *BotHandlers) generateLogsKeyboard(currentPage, totalPages int, user *models.User) tgbotapi.InlineKeyboardMarkup {
@@ -968,26 +1256,25 @@ func (h *BotHandlers) generateLogsKeyboard(currentPage, totalPages int, user *mo
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func (h *BotHandlers) handleLogsPageNavigation(data string, chatID int64, messageID int, user *models.User) {
+func (h *BotHandlers) handleLogsPageNavigation(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
 	pageStr := strings.TrimPrefix(data, "logs_page_")
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
 		page = 1
 	}
-	h.handleViewLogs(chatID, messageID, user, page)
+	h.handleViewLogs(ctx, chatID, messageID, user, page)
 }
 
-func (h *BotHandlers) handleDownloadLogs(chatID int64, messageID int, user *models.User) {
-	now := time.Now()
+func (h *BotHandlers) handleDownloadLogs(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	now := h.localTime(time.Now())
 	month := int(now.Month())
 	year := now.Year()
 
 	if !h.logService.LogFileExists(month, year) {
-		h.editMessage(chatID, messageID, h.t("logs_file_not_found", user))
-		go func() {
-			time.Sleep(2 * time.Second)
-			h.handleStatusLogsMenu(chatID, messageID, user)
-		}()
+		h.editMessage(ctx, chatID, messageID, h.t("logs_file_not_found", user))
+		h.later(func() {
+			h.handleStatusLogsMenu(ctx, chatID, messageID, user)
+		})
 		return
 	}
 
@@ -998,12 +1285,18 @@ func (h *BotHandlers) handleDownloadLogs(chatID int64, messageID int, user *mode
 		"month": fmt.Sprintf("%02d", month),
 		"year":  strconv.Itoa(year),
 	})
+	if head, err := h.logService.GetChainHead(month, year); err == nil && head != "" {
+		doc.Caption += "\n" + h.tParams("logs_chain_head", user, map[string]string{"hash": head})
+	}
+	if head, err := h.auditService.GetChainHead(); err == nil {
+		doc.Caption += "\n" + h.tParams("audit_chain_head", user, map[string]string{"hash": head})
+	}
 
 	if _, err := h.bot.Send(doc); err != nil {
-		log.Printf("Error sending log file: %v", err)
-		h.editMessage(chatID, messageID, h.t("error_generic", user))
+		slog.ErrorContext(ctx, "Error sending log file", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
 		return
 	}
 
-	h.handleStatusLogsMenu(chatID, messageID, user)
+	h.handleStatusLogsMenu(ctx, chatID, messageID, user)
 }
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+)
+
+// telegramPollStale is how long after the last poll the bot checks Telegram
+// directly. Long polls return at least once a minute.
+const telegramPollStale = 2 * time.Minute
+
+// CheckTelegram reports when updates were last polled. If polling has
+// stalled, or updates arrive through a webhook, it asks Telegram directly.
+func (h *BotHandlers) CheckTelegram() (map[string]interface{}, error) {
+	if lastPoll := h.lastPoll.Load(); lastPoll != 0 {
+		last := time.Unix(0, lastPoll)
+		if time.Since(last) < telegramPollStale {
+			return map[string]interface{}{"last_poll": last}, nil
+		}
+	}
+	if _, err := h.bot.GetMe(); err != nil {
+		return nil, fmt.Errorf("Telegram API is unreachable: %w", err)
+	}
+	return map[string]interface{}{"reachable": true}, nil
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	ideaPreviewLength       = 300
+	ideaDigestPreviewLength = 80
+)
+
+var ideaStatusOptions = []models.IdeaStatus{
+	models.IdeaStatusNew,
+	models.IdeaStatusInProgress,
+	models.IdeaStatusDone,
+	models.IdeaStatusRejected,
+}
+
+func (h *BotHandlers) getIdeaStatusName(status models.IdeaStatus, user *models.User) string {
+	if !status.IsValid() {
+		status = models.IdeaStatusNew
+	}
+	return h.t("idea_status_"+string(status), user)
+}
+
+func previewText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:limit])) + "…"
+}
+
+func parseIdeaID(data, prefix string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64)
+}
+
+func (h *BotHandlers) notifyNewIdea(ctx context.Context, idea *models.Idea) {
+	admins, err := h.userService.GetUsersWithIdeaAlerts(models.IdeaAlertsInstant)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting admins for idea alerts", "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		username := idea.Username
+		if username == "" {
+			username = h.t("idea_anonymous", &admin)
+		} else {
+			username = "@" + username
+		}
+		text := h.tParams("idea_alert", &admin, map[string]string{
+			"id":       strconv.FormatInt(idea.ID, 10),
+			"username": username,
+			"content":  previewText(idea.Content, ideaPreviewLength),
+		})
+		if len(idea.Attachments) > 0 {
+			text += "\n\n" + h.tParams("idea_attachments", &admin, map[string]string{"count": strconv.Itoa(len(idea.Attachments))})
+		}
+
+		msg := tgbotapi.NewMessage(admin.TelegramID, text)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_open", &admin), fmt.Sprintf("idea_open_%d", idea.ID)),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_status", &admin), fmt.Sprintf("idea_status_%d", idea.ID)),
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_archive", &admin), fmt.Sprintf("idea_archive_%d", idea.ID)),
+			),
+		)
+		if _, err := h.bot.Send(msg); err != nil {
+			slog.WarnContext(ctx, "Error sending idea alert", "target_id", admin.TelegramID, "error", err)
+		}
+	}
+}
+
+// SendIdeaDigest sends admins who chose the daily digest a summary of the
+// ideas received during the last 24 hours.
+func (h *BotHandlers) SendIdeaDigest(ctx context.Context) error {
+	admins, err := h.userService.GetUsersWithIdeaAlerts(models.IdeaAlertsDigest)
+	if err != nil {
+		return fmt.Errorf("failed to get admins for idea digest: %w", err)
+	}
+	if len(admins) == 0 {
+		return nil
+	}
+
+	ideas, err := h.ideaService.GetIdeasSince(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		return fmt.Errorf("failed to get ideas for digest: %w", err)
+	}
+	if len(ideas) == 0 {
+		return nil
+	}
+
+	for _, admin := range admins {
+		parts := []string{h.tParams("idea_digest_header", &admin, map[string]string{"count": strconv.Itoa(len(ideas))})}
+		for _, idea := range ideas {
+			username := idea.Username
+			if username == "" {
+				username = h.t("idea_anonymous", &admin)
+			} else {
+				username = "@" + username
+			}
+			parts = append(parts, h.tParams("idea_digest_entry", &admin, map[string]string{
+				"id":       strconv.FormatInt(idea.ID, 10),
+				"username": username,
+				"content":  previewText(idea.Content, ideaDigestPreviewLength),
+			}))
+		}
+
+		chunks := joinWithinLimit(parts, maxMessageLength)
+		for i, chunk := range chunks {
+			msg := tgbotapi.NewMessage(admin.TelegramID, chunk)
+			if i == len(chunks)-1 {
+				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+					tgbotapi.NewInlineKeyboardRow(
+						tgbotapi.NewInlineKeyboardButtonData(h.t("btn_read_ideas", &admin), "read_ideas"),
+					),
+				)
+			}
+			if _, err := h.bot.Send(msg); err != nil {
+				slog.WarnContext(ctx, "Error sending idea digest", "target_id", admin.TelegramID, "error", err)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// maxMessageLength is the most UTF-16 code units Telegram accepts in a
+// message.
+const maxMessageLength = 4096
+
+// joinWithinLimit joins parts into as few texts as it can, each under limit
+// UTF-16 code units, breaking only between parts.
+func joinWithinLimit(parts []string, limit int) []string {
+	var texts []string
+	var text string
+	length := 0
+	for _, part := range parts {
+		partLength := len(utf16.Encode([]rune(part)))
+		if length > 0 && length+partLength > limit {
+			texts = append(texts, text)
+			text, length = "", 0
+		}
+		text += part
+		length += partLength
+	}
+	if length > 0 {
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+func (h *BotHandlers) handleIdeaAlertsSettings(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	mode := user.IdeaAlerts
+	if !mode.IsValid() {
+		mode = models.IdeaAlertsOff
+	}
+	text := h.tParams("idea_alerts_info", user, map[string]string{
+		"mode": h.t("idea_alerts_mode_"+string(mode), user),
+	})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_alerts_instant", user), "ideaalerts_instant"),
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_alerts_digest", user), "ideaalerts_digest"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_idea_alerts_off", user), "ideaalerts_off"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+		),
+	)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BotHandlers) handleIdeaAlertsUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	mode := models.IdeaAlertMode(strings.TrimPrefix(data, "ideaalerts_"))
+	if !mode.IsValid() {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	if err := h.userService.UpdateIdeaAlerts(user.TelegramID, mode); err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	user.IdeaAlerts = mode
+	h.handleIdeaAlertsSettings(ctx, chatID, messageID, user)
+}
+
+func (h *BotHandlers) handleIdeaOpen(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	ideaID, err := parseIdeaID(data, "idea_open_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_idea_id", user))
+		return
+	}
+	h.showIdeaByID(ctx, chatID, messageID, ideaID, user)
+}
+
+func (h *BotHandlers) showIdeaByID(ctx context.Context, chatID int64, messageID int, ideaID int64, user *models.User) {
+	ideas, err := h.ideaService.GetActiveIdeas()
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_get_ideas", user))
+		return
+	}
+	for i, idea := range ideas {
+		if idea.ID == ideaID {
+			h.showIdea(ctx, chatID, messageID, ideas, i, user)
+			return
+		}
+	}
+	h.editMessage(ctx, chatID, messageID, h.t("idea_not_found", user))
+}
+
+func (h *BotHandlers) handleIdeaStatusSelect(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	ideaID, err := parseIdeaID(data, "idea_status_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_idea_id", user))
+		return
+	}
+	idea, err := h.ideaService.GetIdeaByID(ideaID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("idea_not_found", user))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(ideaStatusOptions); i += 2 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, status := range ideaStatusOptions[i:min(i+2, len(ideaStatusOptions))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				h.getIdeaStatusName(status, user), fmt.Sprintf("idea_setstatus_%d_%s", ideaID, status),
+			))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), fmt.Sprintf("idea_open_%d", ideaID)),
+	))
+
+	text := h.tParams("idea_status_select", user, map[string]string{
+		"id":     strconv.FormatInt(ideaID, 10),
+		"status": h.getIdeaStatusName(idea.Status, user),
+	})
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleIdeaStatusUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.SplitN(strings.TrimPrefix(data, "idea_setstatus_"), "_", 2)
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	ideaID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_idea_id", user))
+		return
+	}
+
+	if !h.updateIdeaStatus(ctx, ideaID, models.IdeaStatus(parts[1]), chatID, messageID, user) {
+		return
+	}
+
+	h.showIdeaByID(ctx, chatID, messageID, ideaID, user)
+}
+
+func (h *BotHandlers) handleIdeaArchive(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	ideaID, err := parseIdeaID(data, "idea_archive_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_idea_id", user))
+		return
+	}
+
+	if !h.updateIdeaStatus(ctx, ideaID, models.IdeaStatusArchived, chatID, messageID, user) {
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_read_ideas", user), "read_ideas"),
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+		),
+	)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.tParams("idea_archived", user, map[string]string{
+		"id": strconv.FormatInt(ideaID, 10),
+	}), keyboard)
+}
+
+func (h *BotHandlers) updateIdeaStatus(ctx context.Context, ideaID int64, status models.IdeaStatus, chatID int64, messageID int, user *models.User) bool {
+	idea, err := h.ideaService.GetIdeaByID(ideaID)
+	if err == nil {
+		err = h.ideaService.UpdateIdeaStatus(ideaID, status)
+	}
+	if err != nil {
+		if err == models.ErrIdeaNotFound {
+			h.editMessage(ctx, chatID, messageID, h.t("idea_not_found", user))
+		} else {
+			h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		}
+		return false
+	}
+
+	h.audit(ctx, user, models.AuditIdeaStatus, fmt.Sprintf("idea #%d", ideaID), string(idea.Status), string(status))
+	return true
+}
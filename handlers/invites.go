@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"lunobot/services"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// inviteUses and inviteDurations are the limits offered when creating an invite.
+var inviteUses = []int{1, 5, 20}
+
+var inviteDurations = []struct {
+	code     string
+	duration time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+func isInviteStart(message *tgbotapi.Message) bool {
+	return message != nil && message.Command() == "start" &&
+		strings.HasPrefix(message.CommandArguments(), services.InvitePrefix)
+}
+
+func (h *BotHandlers) inviteLink(invite *models.Invite) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", h.bot.Self.UserName, services.InvitePrefix, invite.Code)
+}
+
+func (h *BotHandlers) inviteRoleName(roleID int64, user *models.User) string {
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		return "?"
+	}
+	return h.menu.RoleName(role, h.getUserLang(user))
+}
+
+func (h *BotHandlers) handleInvitesMenu(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	invites, err := h.inviteService.GetActiveInvites()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting invites", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	text := h.t("invites_header", user)
+	if len(invites) == 0 {
+		text += h.t("invites_empty", user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, invite := range invites {
+		text += h.tParams("invite_entry", user, map[string]string{
+			"number": strconv.Itoa(i + 1),
+			"role":   h.inviteRoleName(invite.RoleID, user),
+			"uses":   strconv.Itoa(invite.Uses),
+			"max":    strconv.Itoa(invite.MaxUses),
+			"until":  h.localTime(invite.ExpiresAt).Format("02.01.2006 15:04"),
+			"link":   h.inviteLink(&invite),
+		})
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				h.tParams("btn_invite_revoke", user, map[string]string{"number": strconv.Itoa(i + 1)}),
+				fmt.Sprintf("invdel_%d", invite.ID),
+			),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_invite_new", user), "invite_new"),
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+	))
+
+	msg := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+	msg.DisableWebPagePreview = true
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error showing invites", "error", err)
+	}
+}
+
+func (h *BotHandlers) handleInviteRoleSelect(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, role := range roles {
+		// Everyone gets the user role on /start, so an invite for it is pointless.
+		if role.BuiltIn && role.Name == models.RoleUser {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.menu.RoleName(&role, h.getUserLang(user)), fmt.Sprintf("invrole_%d", role.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "invites"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("invite_role_select", user), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleInviteUsesSelect(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	roleID, err := strconv.ParseInt(strings.TrimPrefix(data, "invrole_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, uses := range inviteUses {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			h.tParams("btn_invite_uses", user, map[string]string{"count": strconv.Itoa(uses)}),
+			fmt.Sprintf("invuses_%d_%d", roleID, uses),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "invite_new"),
+		),
+	)
+
+	text := h.tParams("invite_uses_select", user, map[string]string{"role": h.inviteRoleName(roleID, user)})
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BotHandlers) handleInviteExpirySelect(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "invuses_"), "_")
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, d := range inviteDurations {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			h.t("btn_role_duration_"+d.code, user),
+			fmt.Sprintf("invexp_%s_%s_%s", parts[0], parts[1], d.code),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "invrole_"+parts[0]),
+		),
+	)
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("invite_expiry_select", user), keyboard)
+}
+
+func (h *BotHandlers) handleInviteCreate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "invexp_"), "_")
+	if len(parts) != 3 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	roleID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	maxUses, err := strconv.Atoi(parts[1])
+	if err != nil || maxUses < 1 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	var duration time.Duration
+	for _, d := range inviteDurations {
+		if d.code == parts[2] {
+			duration = d.duration
+		}
+	}
+	if duration == 0 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("role_not_found", user))
+		return
+	}
+
+	invite, err := h.inviteService.CreateInvite(role.ID, maxUses, time.Now().Add(duration), user.TelegramID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating invite", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	h.audit(ctx, user, models.AuditInviteCreate, invite.Code, "",
+		fmt.Sprintf("role=%s uses=%d until=%s", role.Name, invite.MaxUses, h.localTime(invite.ExpiresAt).Format("02.01.2006 15:04")))
+
+	text := h.tParams("invite_created", user, map[string]string{
+		"role":  h.menu.RoleName(role, h.getUserLang(user)),
+		"max":   strconv.Itoa(invite.MaxUses),
+		"until": h.localTime(invite.ExpiresAt).Format("02.01.2006 15:04"),
+		"link":  h.inviteLink(invite),
+	})
+	msg := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "invites"),
+		),
+	))
+	msg.DisableWebPagePreview = true
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending invite link", "error", err)
+	}
+}
+
+func (h *BotHandlers) handleInviteRevoke(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	inviteID, err := strconv.ParseInt(strings.TrimPrefix(data, "invdel_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	if err := h.inviteService.DeleteInvite(inviteID); err != nil && err != models.ErrInviteNotFound {
+		slog.ErrorContext(ctx, "Error revoking invite", "invite_id", inviteID, "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	h.audit(ctx, user, models.AuditInviteRevoke, strconv.FormatInt(inviteID, 10), "", "")
+	h.handleInvitesMenu(ctx, chatID, messageID, user)
+}
+
+// redeemInvite gives the user the role of the invite in the /start payload.
+// Invites never take rights away, so a user whose role has a permission the
+// invite's role lacks keeps it. The invite is then left unused unless it lets
+// a pending user in.
+func (h *BotHandlers) redeemInvite(ctx context.Context, chatID int64, user *models.User, payload string) {
+	code := strings.TrimPrefix(payload, services.InvitePrefix)
+	invite, err := h.inviteService.GetUsableInvite(code)
+	if err == nil {
+		err = h.redeemUsableInvite(ctx, invite, user)
+	}
+	switch err {
+	case nil:
+	case models.ErrInviteNotFound, models.ErrRoleNotFound:
+		h.sendMessage(ctx, chatID, h.t("invite_invalid", user))
+	case models.ErrInviteExpired, models.ErrInviteUsedUp:
+		h.sendMessage(ctx, chatID, h.t("invite_expired", user))
+	case models.ErrLastAdmin:
+		h.sendMessage(ctx, chatID, h.t("invite_not_applied", user))
+	default:
+		slog.WarnContext(ctx, "Error redeeming invite", "code", code, "error", err)
+		h.sendMessage(ctx, chatID, h.t("error_generic", user))
+	}
+}
+
+func (h *BotHandlers) redeemUsableInvite(ctx context.Context, invite *models.Invite, user *models.User) error {
+	role, err := h.roleService.GetRoleByID(invite.RoleID)
+	if err != nil {
+		return err
+	}
+	if user.RoleID == role.ID || services.LosesPermissions(user, role) {
+		// The role stays, but the invite still lets a pending user in.
+		pending := user.Membership != models.MembershipActive
+		if pending {
+			if err := h.inviteService.UseInvite(invite); err != nil {
+				return err
+			}
+			if err := h.activateInvitedUser(ctx, user); err != nil {
+				return err
+			}
+			h.audit(ctx, user, models.AuditInviteRedeem, user.GetDisplayName(), h.roleCode(user), h.roleCode(user))
+		}
+		switch {
+		case user.RoleID == role.ID:
+			h.sendMessage(ctx, user.TelegramID, h.tParams("invite_already_has_role", user, map[string]string{
+				"rights": h.menu.RoleName(role, h.getUserLang(user)),
+			}))
+		case pending:
+			h.sendMessage(ctx, user.TelegramID, h.t("invite_role_kept", user))
+		default:
+			h.sendMessage(ctx, user.TelegramID, h.t("invite_not_applied", user))
+		}
+		return nil
+	}
+
+	// Spend the invite first, so a use taken by someone else meanwhile never
+	// grants its role.
+	if err := h.inviteService.UseInvite(invite); err != nil {
+		return err
+	}
+	before := h.roleCode(user)
+	if err := h.userService.UpdateUserRights(ctx, user.TelegramID, role); err != nil {
+		if refundErr := h.inviteService.RefundInvite(invite); refundErr != nil {
+			slog.ErrorContext(ctx, "Error refunding invite use", "invite_id", invite.ID, "error", refundErr)
+		}
+		return err
+	}
+	user.RoleID = role.ID
+	user.Role = role
+	user.Rights = role.Level()
+
+	if err := h.activateInvitedUser(ctx, user); err != nil {
+		return err
+	}
+	h.audit(ctx, user, models.AuditInviteRedeem, user.GetDisplayName(), before, role.Name)
+	h.sendMessage(ctx, user.TelegramID, h.tParams("invite_redeemed", user, map[string]string{
+		"rights": h.menu.RoleName(role, h.getUserLang(user)),
+	}))
+
+	if creator, err := h.userService.GetUserByTelegramID(invite.CreatedBy); err == nil && creator.TelegramID != user.TelegramID {
+		h.sendMessage(ctx, creator.TelegramID, h.tParams("invite_redeemed_notice", creator, map[string]string{
+			"user":   user.GetDisplayName(),
+			"rights": h.menu.RoleName(role, h.getUserLang(creator)),
+		}))
+	}
+	return nil
+}
+
+// activateInvitedUser lets a pending user in. An invite is an admin's
+// approval, whether or not it changes the user's role.
+func (h *BotHandlers) activateInvitedUser(ctx context.Context, user *models.User) error {
+	if user.Membership == models.MembershipActive {
+		return nil
+	}
+	if err := h.userService.UpdateUserMembership(ctx, user.TelegramID, models.MembershipActive); err != nil {
+		return err
+	}
+	h.audit(ctx, user, models.AuditUserApprove, user.GetDisplayName(), string(user.Membership), string(models.MembershipActive))
+	user.Membership = models.MembershipActive
+	return nil
+}
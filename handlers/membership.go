@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (h *BotHandlers) membershipButtons(target *models.User, user *models.User) []tgbotapi.InlineKeyboardButton {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_member_approve", user), fmt.Sprintf("member_approve_%d", target.TelegramID)),
+	)
+	if target.Membership == models.MembershipPending {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(h.t("btn_member_reject", user), fmt.Sprintf("member_reject_%d", target.TelegramID)))
+	}
+	return row
+}
+
+// NotifyPendingUser asks everyone who manages users to approve or reject a
+// user who has just written to the bot in closed-membership mode.
+func (h *BotHandlers) NotifyPendingUser(ctx context.Context, target *models.User) {
+	admins, err := h.userService.GetUsersWithPermission(models.PermUsersManage)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting admins for membership request", "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		msg := tgbotapi.NewMessage(admin.TelegramID, h.tParams("membership_request_alert", &admin, map[string]string{
+			"user": target.GetDisplayName(),
+			"id":   strconv.FormatInt(target.TelegramID, 10),
+		}))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(h.membershipButtons(target, &admin))
+		if _, err := h.bot.Send(msg); err != nil {
+			slog.WarnContext(ctx, "Error sending membership request", "target_id", admin.TelegramID, "error", err)
+		}
+	}
+}
+
+func (h *BotHandlers) handleApprovalQueue(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	h.showApprovalQueue(ctx, chatID, messageID, "", user)
+}
+
+// showApprovalQueue lists the oldest page of pending users below the
+// optional result of the last decision.
+func (h *BotHandlers) showApprovalQueue(ctx context.Context, chatID int64, messageID int, result string, user *models.User) {
+	filter := models.UserFilter{Membership: models.MembershipPending}
+	users, _, err := h.userService.GetUsers(filter, 1)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting pending users", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	text := result
+	if text != "" {
+		text += "\n\n"
+	}
+	if len(users) == 0 {
+		text += h.t("approvals_empty", user)
+	} else {
+		text += h.t("approvals_header", user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, u := range users {
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("👤 %s · %s", u.GetDisplayName(), h.localTime(u.CreatedAt).Format("02.01.2006 15:04")),
+					fmt.Sprintf("user_%d", u.TelegramID),
+				),
+			),
+			h.membershipButtons(&u, user),
+		)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "user_directory"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleMembershipDecision(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	approve := strings.HasPrefix(data, "member_approve_")
+	prefix := "member_reject_"
+	if approve {
+		prefix = "member_approve_"
+	}
+	targetID, err := parseTargetID(data, prefix)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+
+	// Another admin may have decided already.
+	if approve && target.Membership == models.MembershipActive || !approve && target.Membership != models.MembershipPending {
+		h.showApprovalQueue(ctx, chatID, messageID, h.tParams("membership_already_decided", user, map[string]string{
+			"user": target.GetDisplayName(),
+		}), user)
+		return
+	}
+
+	membership, action, key := models.MembershipRejected, models.AuditUserReject, "rejected"
+	if approve {
+		membership, action, key = models.MembershipActive, models.AuditUserApprove, "approved"
+	}
+	if err := h.userService.UpdateUserMembership(ctx, targetID, membership); err != nil {
+		slog.ErrorContext(ctx, "Error updating membership", "target_id", targetID, "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	h.audit(ctx, user, action, target.GetDisplayName(), string(target.Membership), string(membership))
+	h.sendMessage(ctx, target.TelegramID, h.t("membership_"+key+"_notice", target))
+	h.showApprovalQueue(ctx, chatID, messageID, h.tParams("membership_"+key, user, map[string]string{
+		"user": target.GetDisplayName(),
+	}), user)
+}
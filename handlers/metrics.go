@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"lunobot/metrics"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil && update.Message.IsCommand():
+		return "command"
+	case update.Message != nil:
+		return "message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	default:
+		return "other"
+	}
+}
+
+// publicCallbacks are the routes anyone may use, which callbackRules leaves
+// out.
+var publicCallbacks = []callbackRule{
+	{data: "back_to_menu"},
+	{data: "check_status"},
+	{data: "send_idea"},
+	{data: "notifications"},
+	{data: "notifications_toggle"},
+	{data: "change_language"},
+	{data: "lang_", prefix: true},
+	{data: "request_access"},
+	{data: "reqrole_", prefix: true},
+}
+
+// callbackRoute names the route for callback data without the IDs and pages
+// it carries. Data that matches no route, which anyone can forge, is counted
+// as "other" so that metrics stay at a bounded number of labels.
+func callbackRoute(data string) string {
+	for _, rules := range [][]callbackRule{callbackRules, publicCallbacks} {
+		for _, rule := range rules {
+			if data == rule.data || rule.prefix && strings.HasPrefix(data, rule.data) {
+				return rule.data
+			}
+		}
+	}
+	return "other"
+}
+
+func observeCallback(data string, start time.Time) {
+	metrics.CallbackDuration.Observe(time.Since(start).Seconds(), callbackRoute(data))
+}
+
+// countUserStates counts the conversation states that have not expired yet,
+// whether or not the cleanup has removed the others.
+func (h *BotHandlers) countUserStates() float64 {
+	h.stateMutex.RLock()
+	defer h.stateMutex.RUnlock()
+
+	now := time.Now()
+	active := 0
+	for _, state := range h.userStates {
+		if !now.After(state.Expires) {
+			active++
+		}
+	}
+	return float64(active)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Data sent with each outgoing webhook event.
+
+type statusWebhookData struct {
+	Open bool   `json:"open"`
+	By   string `json:"by"`
+}
+
+type keysWebhookData struct {
+	Location string `json:"location"`
+	By       string `json:"by"`
+}
+
+// ideaWebhookData leaves out the author, who may expect ideas to stay
+// between them and the admins.
+type ideaWebhookData struct {
+	ID          int64     `json:"id"`
+	Content     string    `json:"content"`
+	Attachments int       `json:"attachments"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type autoCloseWebhookData struct {
+	Keys     string `json:"keys"`
+	ClosedBy string `json:"closed_by"`
+}
+
+func newIdeaWebhookData(idea *models.Idea) ideaWebhookData {
+	return ideaWebhookData{
+		ID:          idea.ID,
+		Content:     idea.Content,
+		Attachments: len(idea.Attachments),
+		CreatedAt:   idea.CreatedAt,
+	}
+}
+
+// AutoClosed tells webhooks that the scheduler has closed Lunoteka.
+func (h *BotHandlers) AutoClosed(ctx context.Context, settings *models.AutoCloseSettings, closedBy string) {
+	keys := keysLocationCode(!settings.KeysToLobby)
+	h.webhookService.Emit(ctx, models.WebhookStatusChanged, statusWebhookData{Open: false, By: closedBy})
+	h.webhookService.Emit(ctx, models.WebhookKeysMoved, keysWebhookData{Location: keys, By: closedBy})
+	h.webhookService.Emit(ctx, models.WebhookAutoClose, autoCloseWebhookData{Keys: keys, ClosedBy: closedBy})
+}
+
+func (h *BotHandlers) handleWebhookLog(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	page, err := strconv.Atoi(strings.TrimPrefix(data, "webhooklog_"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	deliveries, totalPages, err := h.webhookService.GetDeliveries(page)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting webhook deliveries", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	back := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "status_logs"),
+	)
+	if len(deliveries) == 0 {
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("webhook_log_empty", user), tgbotapi.NewInlineKeyboardMarkup(back))
+		return
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	text := h.tParams("webhook_log_header", user, map[string]string{
+		"page":        strconv.Itoa(page),
+		"total_pages": strconv.Itoa(totalPages),
+	})
+	for _, delivery := range deliveries {
+		host := delivery.URL
+		if u, err := url.Parse(delivery.URL); err == nil {
+			host = u.Host
+		}
+		text += h.tParams("webhook_log_entry", user, map[string]string{
+			"date":     h.localTime(delivery.UpdatedAt).Format("02.01.2006"),
+			"time":     h.localTime(delivery.UpdatedAt).Format("15:04:05"),
+			"event":    delivery.Event,
+			"host":     host,
+			"status":   h.t("webhook_status_"+string(delivery.Status), user),
+			"attempts": strconv.Itoa(delivery.Attempts),
+		})
+		if delivery.Error != "" {
+			text += h.tParams("webhook_log_error", user, map[string]string{
+				"error": previewText(delivery.Error, ideaDigestPreviewLength),
+			})
+		}
+		text += "\n"
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var navRow []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("webhooklog_%d", page-1)))
+	}
+	if page < totalPages {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("webhooklog_%d", page+1)))
+	}
+	if len(navRow) > 0 {
+		rows = append(rows, navRow)
+	}
+	rows = append(rows, back)
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"lunobot/models"
+	"strings"
+)
+
+// callbackRule maps callback data to the permission required to handle it.
+// Rules are matched in order, so more specific prefixes must come first.
+type callbackRule struct {
+	data       string
+	prefix     bool
+	permission models.Permission
+}
+
+var callbackRules = []callbackRule{
+	{data: "set_open_status", permission: models.PermStatusChange},
+	{data: "open_", prefix: true, permission: models.PermStatusChange},
+	{data: "set_tech_status", permission: models.PermKeysChange},
+	{data: "tech_", prefix: true, permission: models.PermKeysChange},
+	{data: "read_ideas", permission: models.PermIdeasRead},
+	{data: "idea_alerts", permission: models.PermIdeasRead},
+	{data: "ideaalerts_", prefix: true, permission: models.PermIdeasRead},
+	{data: "idea_delete_", prefix: true, permission: models.PermIdeasManage},
+	{data: "idea_status_", prefix: true, permission: models.PermIdeasManage},
+	{data: "idea_setstatus_", prefix: true, permission: models.PermIdeasManage},
+	{data: "idea_archive_", prefix: true, permission: models.PermIdeasManage},
+	{data: "idea_", prefix: true, permission: models.PermIdeasRead},
+	{data: "create_broadcast", permission: models.PermBroadcastSend},
+	{data: "set_rights", permission: models.PermRightsManage},
+	{data: "rights_", prefix: true, permission: models.PermRightsManage},
+	{data: "roles", permission: models.PermRightsManage},
+	{data: "role_", prefix: true, permission: models.PermRightsManage},
+	{data: "roleperm_", prefix: true, permission: models.PermRightsManage},
+	{data: "roledel_", prefix: true, permission: models.PermRightsManage},
+	{data: "invites", permission: models.PermRightsManage},
+	{data: "invite_new", permission: models.PermRightsManage},
+	{data: "invrole_", prefix: true, permission: models.PermRightsManage},
+	{data: "invuses_", prefix: true, permission: models.PermRightsManage},
+	{data: "invexp_", prefix: true, permission: models.PermRightsManage},
+	{data: "invdel_", prefix: true, permission: models.PermRightsManage},
+	{data: "reqok_", prefix: true, permission: models.PermRightsManage},
+	{data: "reqno_", prefix: true, permission: models.PermRightsManage},
+	{data: "reqconfirm_", prefix: true, permission: models.PermRightsManage},
+	{data: "rightsdur_", prefix: true, permission: models.PermRightsManage},
+	{data: "rightsconfirm_", prefix: true, permission: models.PermRightsManage},
+	{data: "rightscancel", permission: models.PermRightsManage},
+	{data: "userrole_", prefix: true, permission: models.PermRightsManage},
+	{data: "userroleset_", prefix: true, permission: models.PermRightsManage},
+	{data: "user_directory", permission: models.PermUsersManage},
+	{data: "users_", prefix: true, permission: models.PermUsersManage},
+	{data: "user_", prefix: true, permission: models.PermUsersManage},
+	{data: "userban_", prefix: true, permission: models.PermUsersManage},
+	{data: "usermute_", prefix: true, permission: models.PermUsersManage},
+	{data: "userrestrict_", prefix: true, permission: models.PermUsersManage},
+	{data: "userrestrictok_", prefix: true, permission: models.PermUsersManage},
+	{data: "usermsg_", prefix: true, permission: models.PermUsersManage},
+	{data: "approvals", permission: models.PermUsersManage},
+	{data: "member_", prefix: true, permission: models.PermUsersManage},
+	{data: "auto_close", prefix: true, permission: models.PermAutoCloseManage},
+	{data: "autoclose_keys_", prefix: true, permission: models.PermAutoCloseManage},
+	{data: "status_logs", permission: models.PermLogsRead},
+	{data: "view_logs", permission: models.PermLogsRead},
+	{data: "verify_logs", permission: models.PermLogsRead},
+	{data: "download_logs", permission: models.PermLogsRead},
+	{data: "logs_page_", prefix: true, permission: models.PermLogsRead},
+	{data: "webhooklog_", prefix: true, permission: models.PermLogsRead},
+	{data: "audit_", prefix: true, permission: models.PermAuditRead},
+}
+
+// statePermissions lists the permission required to complete each
+// multi-step flow, in case the user's role changed since it started.
+var statePermissions = map[string]models.Permission{
+	"waiting_username":           models.PermRightsManage,
+	"waiting_role_name":          models.PermRightsManage,
+	"waiting_role_expiry":        models.PermRightsManage,
+	"waiting_user_message":       models.PermUsersManage,
+	"waiting_restriction_reason": models.PermUsersManage,
+	"waiting_broadcast":          models.PermBroadcastSend,
+	"waiting_auto_close_time":    models.PermAutoCloseManage,
+}
+
+// callbackPermission returns the permission required for the callback data,
+// or false if the callback is available to everyone.
+func callbackPermission(data string) (models.Permission, bool) {
+	for _, rule := range callbackRules {
+		if data == rule.data || rule.prefix && strings.HasPrefix(data, rule.data) {
+			return rule.permission, true
+		}
+	}
+	return "", false
+}
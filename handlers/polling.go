@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Start polls Telegram for updates until ctx is done. A full worker queue
+// holds back polling until there is room again; Telegram keeps the updates
+// meanwhile. Only updates handed to the workers are confirmed, so the others
+// are sent again after a restart.
+func (h *BotHandlers) Start(ctx context.Context) {
+	// Telegram refuses to poll while a webhook is set.
+	if _, err := h.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		slog.ErrorContext(ctx, "Error removing webhook", "error", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	batches, acks := h.pollUpdates(ctx, u)
+	offset := 0
+	defer func() {
+		if offset != 0 {
+			confirm := tgbotapi.UpdateConfig{Offset: offset, Limit: 1}
+			if _, err := h.bot.Request(confirm); err != nil {
+				slog.Error("Error confirming handled updates", "error", err)
+			}
+		}
+		slog.Info("Bot stopped")
+	}()
+	for {
+		select {
+		case batch := <-batches:
+			for _, update := range batch {
+				if !h.updates.dispatch(ctx, update) {
+					return
+				}
+				offset = update.UpdateID + 1
+			}
+			select {
+			case acks <- offset:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollUpdates fetches updates until ctx is done and sends them in batches.
+// The next batch is only requested once the offset after the previous one
+// comes back on the acknowledgement channel: requesting it confirms the
+// earlier updates to Telegram, so none may be confirmed before the workers
+// have them.
+func (h *BotHandlers) pollUpdates(ctx context.Context, config tgbotapi.UpdateConfig) (<-chan []tgbotapi.Update, chan<- int) {
+	batches := make(chan []tgbotapi.Update)
+	acks := make(chan int)
+	go func() {
+		for ctx.Err() == nil {
+			updates, err := h.getUpdates(config)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to get updates, retrying in 3 seconds", "error", err)
+				select {
+				case <-time.After(3 * time.Second):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			h.lastPoll.Store(time.Now().UnixNano())
+
+			var batch []tgbotapi.Update
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					batch = append(batch, update)
+				}
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case config.Offset = <-acks:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return batches, acks
+}
+
+// getUpdates works like BotAPI.GetUpdates, but users picked with the user
+// picker are copied into the message's Contact, so they are handled the same
+// way as a shared contact.
+func (h *BotHandlers) getUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	resp, err := h.bot.Request(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []tgbotapi.Update
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, err
+	}
+	var shared []usersSharedUpdate
+	if err := json.Unmarshal(resp.Result, &shared); err != nil {
+		return nil, err
+	}
+
+	for i := range updates {
+		if i < len(shared) {
+			copyPickedUser(&updates[i], &shared[i])
+		}
+	}
+	return updates, nil
+}
+
+// decodeUpdate decodes a single update as posted to the webhook, with the
+// picked user copied like getUpdates does.
+func decodeUpdate(data []byte) (tgbotapi.Update, error) {
+	var update tgbotapi.Update
+	if err := json.Unmarshal(data, &update); err != nil {
+		return update, err
+	}
+	var shared usersSharedUpdate
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return update, err
+	}
+	copyPickedUser(&update, &shared)
+	return update, nil
+}
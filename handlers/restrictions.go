@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxRestrictionReasonLength = 200
+
+// restrictionDetails returns the reason and end of target's ban or mute,
+// one per line, in viewer's language.
+func (h *BotHandlers) restrictionDetails(target, viewer *models.User) string {
+	var text string
+	if target.RestrictionReason != "" {
+		text += "\n" + h.tParams("restriction_reason", viewer, map[string]string{"reason": target.RestrictionReason})
+	}
+	if !target.RestrictedUntil.IsZero() {
+		text += "\n" + h.tParams("restriction_until", viewer, map[string]string{
+			"until": h.localTime(target.RestrictedUntil).Format("02.01.2006 15:04"),
+		})
+	}
+	return text
+}
+
+// restrictionNotice tells a banned or muted user what happened.
+func (h *BotHandlers) restrictionNotice(key string, user *models.User) string {
+	return h.t(key, user) + h.restrictionDetails(user, user)
+}
+
+func (h *BotHandlers) formatRestriction(target, viewer *models.User) string {
+	key := "user_profile_muted"
+	if target.Banned {
+		key = "user_profile_banned"
+	}
+	return h.t(key, viewer) + h.restrictionDetails(target, viewer)
+}
+
+// handleUserRestrict lifts the target's ban or mute, or asks how long a new
+// one should last. Restricting someone who can manage rights has to be
+// confirmed first, with userrestrictok_.
+func (h *BotHandlers) handleUserRestrict(ctx context.Context, data, prefix string, chatID int64, messageID int, user *models.User) {
+	targetID, err := parseTargetID(data, prefix)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	if targetID == user.TelegramID {
+		h.sendMessage(ctx, chatID, h.t("users_ban_self", user))
+		return
+	}
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+
+	kind := strings.TrimSuffix(strings.TrimPrefix(prefix, "user"), "_")
+	if kind == "ban" && target.Banned || kind == "mute" && target.Muted {
+		h.liftRestriction(ctx, user, target)
+		h.showUserProfile(ctx, chatID, messageID, targetID, user)
+		return
+	}
+
+	if target.HasPermission(models.PermRightsManage) {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_confirm", user), fmt.Sprintf("userrestrictok_%s_%d", kind, targetID)),
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_cancel", user), fmt.Sprintf("user_%d", targetID)),
+			),
+		)
+		text := h.tParams("restriction_admin_confirm_"+kind, user, map[string]string{"user": target.GetDisplayName()})
+		h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
+		return
+	}
+	h.askRestrictionDuration(ctx, kind, target, chatID, messageID, user)
+}
+
+// handleUserRestrictConfirm goes on with restricting someone who can manage
+// rights once that was confirmed.
+func (h *BotHandlers) handleUserRestrictConfirm(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	kind, id, ok := strings.Cut(strings.TrimPrefix(data, "userrestrictok_"), "_")
+	targetID, err := strconv.ParseInt(id, 10, 64)
+	if !ok || err != nil || kind != "ban" && kind != "mute" {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	if targetID == user.TelegramID {
+		h.sendMessage(ctx, chatID, h.t("users_ban_self", user))
+		return
+	}
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+	h.askRestrictionDuration(ctx, kind, target, chatID, messageID, user)
+}
+
+func (h *BotHandlers) askRestrictionDuration(ctx context.Context, kind string, target *models.User, chatID int64, messageID int, user *models.User) {
+	targetID := target.TelegramID
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_permanent", user), fmt.Sprintf("userrestrict_%s_%d_perm", kind, targetID)),
+		),
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	for _, d := range roleDurations {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			h.t("btn_role_duration_"+d.code, user), fmt.Sprintf("userrestrict_%s_%d_%s", kind, targetID, d.code),
+		))
+	}
+	rows = append(rows, row, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), fmt.Sprintf("user_%d", targetID)),
+	))
+
+	text := h.tParams("restriction_duration_"+kind, user, map[string]string{"user": target.GetDisplayName()})
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleUserRestrictDuration(ctx context.Context, data string, userID, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "userrestrict_"), "_")
+	if len(parts) != 3 || parts[0] != "ban" && parts[0] != "mute" {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	targetID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	var until int64
+	if parts[2] != "perm" {
+		for _, d := range roleDurations {
+			if d.code == parts[2] {
+				until = time.Now().Add(d.duration).Unix()
+			}
+		}
+		if until == 0 {
+			h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+			return
+		}
+	}
+
+	h.setUserState(userID, "waiting_restriction_reason", map[string]interface{}{
+		"kind":      parts[0],
+		"target_id": targetID,
+		"until":     until,
+	})
+	h.editMessage(ctx, chatID, messageID, h.t("restriction_reason_prompt", user))
+}
+
+func (h *BotHandlers) handleRestrictionReason(ctx context.Context, message *tgbotapi.Message, state *UserState, user *models.User) {
+	chatID := message.Chat.ID
+	kind, _ := state.Data["kind"].(string)
+	targetID, ok := state.Data["target_id"].(int64)
+	untilUnix, _ := state.Data["until"].(int64)
+	if !ok {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("error_data_processing", user))
+		return
+	}
+
+	reason := strings.TrimSpace(message.Text)
+	if reason == "" || utf8.RuneCountInString(reason) > maxRestrictionReasonLength {
+		h.sendMessage(ctx, chatID, h.t("restriction_reason_invalid", user))
+		return
+	}
+	h.clearUserState(user.TelegramID)
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.sendMessage(ctx, chatID, h.t("user_not_found", user))
+		return
+	}
+
+	var until time.Time
+	if untilUnix > 0 {
+		until = time.Unix(untilUnix, 0)
+	}
+	action, noticeKey := models.AuditUserMute, "muted_notice"
+	if kind == "ban" {
+		action, noticeKey = models.AuditUserBan, "banned_notice"
+		err = h.userService.BanUser(ctx, targetID, reason, until)
+	} else {
+		err = h.userService.MuteUser(ctx, targetID, reason, until)
+	}
+	if err == models.ErrLastAdmin {
+		h.sendMessage(ctx, chatID, h.t("rights_last_admin", user))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error restricting user", "target_id", targetID, "error", err)
+		h.sendMessage(ctx, chatID, h.t("error_generic", user))
+		return
+	}
+
+	target.Banned, target.Muted = kind == "ban", kind == "mute"
+	target.RestrictionReason, target.RestrictedUntil = reason, until
+
+	after := reason
+	if !until.IsZero() {
+		after += " until " + h.localTime(until).Format("02.01.2006 15:04")
+	}
+	h.audit(ctx, user, action, target.GetDisplayName(), "", after)
+	h.sendMessage(ctx, target.TelegramID, h.restrictionNotice(noticeKey, target))
+
+	msg := tgbotapi.NewMessage(chatID, h.tParams("restriction_applied", user, map[string]string{
+		"user": target.GetDisplayName(),
+	})+"\n"+h.formatRestriction(target, user))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), fmt.Sprintf("user_%d", targetID)),
+		),
+	)
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending restriction confirmation", "error", err)
+	}
+}
+
+func (h *BotHandlers) liftRestriction(ctx context.Context, user, target *models.User) {
+	if err := h.userService.LiftRestriction(ctx, target.TelegramID); err != nil {
+		slog.ErrorContext(ctx, "Error lifting restriction", "target_id", target.TelegramID, "error", err)
+		return
+	}
+
+	action := models.AuditUserUnmute
+	if target.Banned {
+		action = models.AuditUserUnban
+	}
+	h.audit(ctx, user, action, target.GetDisplayName(), target.RestrictionReason, "")
+	h.sendMessage(ctx, target.TelegramID, h.t("restriction_lifted_notice", target))
+}
+
+// LiftExpiredRestrictions lifts bans and mutes that have run out and tells
+// the users they are over.
+func (h *BotHandlers) LiftExpiredRestrictions(ctx context.Context) error {
+	lifted, err := h.userService.LiftExpiredRestrictions(ctx)
+
+	system := &models.User{FirstName: "scheduler"}
+	for _, target := range lifted {
+		action := models.AuditUserUnmute
+		if target.Banned {
+			action = models.AuditUserUnban
+		}
+		h.audit(ctx, system, action, target.GetDisplayName(), target.RestrictionReason, "")
+		h.sendMessage(ctx, target.TelegramID, h.t("restriction_lifted_notice", &target))
+	}
+	return err
+}
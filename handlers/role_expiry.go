@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const roleExpiryLayout = "02.01.2006 15:04"
+
+// roleDurations are the preset lengths offered for temporary roles.
+var roleDurations = []struct {
+	code     string
+	duration time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"3d", 3 * 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+func (h *BotHandlers) showRoleDurations(ctx context.Context, chatID int64, messageID int, role *models.Role, user *models.User) {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_permanent", user), fmt.Sprintf("rightsdur_%d_perm", role.ID)),
+		),
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	for _, d := range roleDurations {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			h.t("btn_role_duration_"+d.code, user), fmt.Sprintf("rightsdur_%d_%s", role.ID, d.code),
+		))
+	}
+	rows = append(rows, row,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_until", user), fmt.Sprintf("rightsdur_%d_custom", role.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "set_rights"),
+		),
+	)
+
+	text := h.tParams("role_duration_select", user, map[string]string{
+		"rights": h.menu.RoleName(role, h.getUserLang(user)),
+	})
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleRoleDuration(ctx context.Context, data string, userID, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "rightsdur_"), "_")
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	roleID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_invalid_rights", user))
+		return
+	}
+
+	switch parts[1] {
+	case "perm":
+		h.promptRightsTarget(ctx, userID, chatID, messageID, roleID, time.Time{}, user)
+		return
+	case "custom":
+		h.setUserState(userID, "waiting_role_expiry", map[string]interface{}{
+			"role_id": roleID,
+		})
+		h.editMessage(ctx, chatID, messageID, h.t("role_expiry_prompt", user))
+		return
+	}
+
+	for _, d := range roleDurations {
+		if d.code == parts[1] {
+			h.promptRightsTarget(ctx, userID, chatID, messageID, roleID, time.Now().Add(d.duration), user)
+			return
+		}
+	}
+	h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+}
+
+func (h *BotHandlers) handleRoleExpiryInput(ctx context.Context, message *tgbotapi.Message, state *UserState, user *models.User) {
+	chatID := message.Chat.ID
+	roleID, ok := state.Data["role_id"].(int64)
+	if !ok {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("error_data_processing", user))
+		return
+	}
+
+	input := strings.TrimSpace(message.Text)
+	expiresAt, err := time.ParseInLocation(roleExpiryLayout, input, h.location)
+	if err != nil {
+		// A date alone means the role lasts until the end of that day.
+		var day time.Time
+		if day, err = time.ParseInLocation("02.01.2006", input, h.location); err == nil {
+			expiresAt = day.AddDate(0, 0, 1).Add(-time.Minute)
+		}
+	}
+	if err != nil {
+		h.sendMessage(ctx, chatID, h.t("role_expiry_invalid", user))
+		return
+	}
+	if !expiresAt.After(time.Now()) {
+		h.sendMessage(ctx, chatID, h.t("role_expiry_past", user))
+		return
+	}
+
+	h.promptRightsTarget(ctx, user.TelegramID, chatID, 0, roleID, expiresAt, user)
+}
+
+// RevokeExpiredRoles takes back expired temporary roles and tells both the
+// user and the admin who granted the role.
+func (h *BotHandlers) RevokeExpiredRoles(ctx context.Context) error {
+	revoked, err := h.userService.RevokeExpiredRoles(ctx)
+
+	system := &models.User{FirstName: "scheduler"}
+	for _, r := range revoked {
+		target := r.User
+		h.audit(ctx, system, models.AuditRightsChange, target.GetDisplayName(), r.Expired.Name, r.Restored.Name)
+		slog.InfoContext(ctx, "Temporary role expired", "target_id", target.TelegramID, "expired", r.Expired.Name, "restored", r.Restored.Name)
+
+		h.sendMessage(ctx, target.TelegramID, h.tParams("role_expired_notice", &target, map[string]string{
+			"rights":   h.menu.RoleName(r.Expired, h.getUserLang(&target)),
+			"restored": h.menu.RoleName(r.Restored, h.getUserLang(&target)),
+		}))
+
+		if target.RoleGrantedBy == 0 || target.RoleGrantedBy == target.TelegramID {
+			continue
+		}
+		granter, err := h.userService.GetUserByTelegramID(target.RoleGrantedBy)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting user who granted an expired role", "granted_by", target.RoleGrantedBy, "error", err)
+			continue
+		}
+		h.sendMessage(ctx, granter.TelegramID, h.tParams("role_expired_admin_notice", granter, map[string]string{
+			"user":     target.GetDisplayName(),
+			"rights":   h.menu.RoleName(r.Expired, h.getUserLang(granter)),
+			"restored": h.menu.RoleName(r.Restored, h.getUserLang(granter)),
+		}))
+	}
+	return err
+}
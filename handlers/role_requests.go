@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"lunobot/services"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (h *BotHandlers) handleRequestAccess(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	pending, err := h.roleRequestService.HasPendingRequest(user.TelegramID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking role requests", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+	if pending {
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_request_pending", user), h.backToMenuKeyboard(user))
+		return
+	}
+
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, role := range roles {
+		if role.ID == user.RoleID || role.BuiltIn && role.Name == models.RoleUser {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.menu.RoleName(&role, h.getUserLang(user)), fmt.Sprintf("reqrole_%d", role.ID)),
+		))
+	}
+	if len(rows) == 0 {
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_request_none", user), h.backToMenuKeyboard(user))
+		return
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_request_select", user), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleRoleRequestRole(ctx context.Context, data string, userID, chatID int64, messageID int, user *models.User) {
+	roleID, err := strconv.ParseInt(strings.TrimPrefix(data, "reqrole_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("role_not_found", user))
+		return
+	}
+
+	h.setUserState(userID, "waiting_role_request_reason", map[string]interface{}{
+		"role_id": roleID,
+	})
+	h.editMessage(ctx, chatID, messageID, h.tParams("role_request_reason_prompt", user, map[string]string{
+		"rights": h.menu.RoleName(role, h.getUserLang(user)),
+	}))
+}
+
+func (h *BotHandlers) handleRoleRequestReason(ctx context.Context, message *tgbotapi.Message, state *UserState, user *models.User) {
+	chatID := message.Chat.ID
+	roleID, ok := state.Data["role_id"].(int64)
+	if !ok {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("error_data_processing", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("role_not_found", user))
+		return
+	}
+
+	request, err := h.roleRequestService.CreateRequest(user.TelegramID, roleID, message.Text)
+	if err == models.ErrInvalidRoleRequestReason {
+		h.sendMessage(ctx, chatID, h.t("role_request_reason_invalid", user))
+		return
+	}
+	h.clearUserState(user.TelegramID)
+	switch err {
+	case nil:
+		h.sendMessage(ctx, chatID, h.t("role_request_sent", user))
+		h.notifyRoleRequest(ctx, request, role, user)
+	case models.ErrRoleRequestPending:
+		h.sendMessage(ctx, chatID, h.t("role_request_pending", user))
+	default:
+		slog.ErrorContext(ctx, "Error creating role request", "error", err)
+		h.sendMessage(ctx, chatID, h.t("error_generic", user))
+	}
+	h.sendMainMenu(ctx, chatID, user)
+}
+
+// notifyRoleRequest sends the request to everyone who manages rights, with
+// buttons to approve or deny it.
+func (h *BotHandlers) notifyRoleRequest(ctx context.Context, request *models.RoleRequest, role *models.Role, requester *models.User) {
+	admins, err := h.userService.GetUsersWithPermission(models.PermRightsManage)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting admins for role request", "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		msg := tgbotapi.NewMessage(admin.TelegramID, h.tParams("role_request_alert", &admin, map[string]string{
+			"user":    requester.GetDisplayName(),
+			"id":      strconv.FormatInt(requester.TelegramID, 10),
+			"current": h.getUserRoleName(requester, &admin),
+			"rights":  h.menu.RoleName(role, h.getUserLang(&admin)),
+			"reason":  request.Reason,
+		}))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_request_approve", &admin), fmt.Sprintf("reqok_%d", request.ID)),
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_request_deny", &admin), fmt.Sprintf("reqno_%d", request.ID)),
+			),
+		)
+		if _, err := h.bot.Send(msg); err != nil {
+			slog.WarnContext(ctx, "Error sending role request", "target_id", admin.TelegramID, "error", err)
+		}
+	}
+}
+
+// handleRoleRequestDecision approves (reqok_, or reqconfirm_ after a demotion
+// was confirmed) or denies (reqno_) a role request. The request is claimed
+// before the role is granted, so admins deciding at once cannot both act on
+// it. A request whose role could not be given goes back to pending.
+func (h *BotHandlers) handleRoleRequestDecision(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	approve := !strings.HasPrefix(data, "reqno_")
+	confirmed := strings.HasPrefix(data, "reqconfirm_")
+	requestID, err := strconv.ParseInt(data[strings.Index(data, "_")+1:], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	request, err := h.roleRequestService.GetRequest(requestID)
+	if err == models.ErrRoleRequestNotFound {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting role request", "request_id", requestID, "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+	if request.Status != models.RoleRequestPending {
+		h.editMessage(ctx, chatID, messageID, h.t("role_request_already_decided", user))
+		return
+	}
+
+	requester, err := h.userService.GetUserByTelegramID(request.TelegramID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(request.RoleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("role_not_found", user))
+		return
+	}
+
+	if approve {
+		if !confirmed && services.IsAdminDemotion(requester, role) {
+			keyboard := tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData(h.t("btn_confirm", user), fmt.Sprintf("reqconfirm_%d", request.ID)),
+					tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_request_deny", user), fmt.Sprintf("reqno_%d", request.ID)),
+				),
+			)
+			h.editMessageWithKeyboard(ctx, chatID, messageID, h.tParams("rights_demote_confirm", user, map[string]string{
+				"user":   requester.GetDisplayName(),
+				"rights": h.menu.RoleName(role, h.getUserLang(user)),
+			}), keyboard)
+			return
+		}
+	}
+
+	request, err = h.roleRequestService.Decide(requestID, approve, user.TelegramID)
+	if err != nil {
+		switch err {
+		case models.ErrRoleRequestDecided:
+			h.editMessage(ctx, chatID, messageID, h.t("role_request_already_decided", user))
+		case models.ErrRoleRequestNotFound:
+			h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		default:
+			slog.ErrorContext(ctx, "Error deciding role request", "request_id", requestID, "error", err)
+			h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		}
+		return
+	}
+	if approve {
+		// ChangeRole tells the requester about their new role.
+		if err := h.ChangeRole(ctx, user, requester, role, time.Time{}); err != nil {
+			if reopenErr := h.roleRequestService.Reopen(requestID); reopenErr != nil {
+				slog.ErrorContext(ctx, "Error reopening role request", "request_id", requestID, "error", reopenErr)
+			}
+			if err == models.ErrLastAdmin {
+				h.editMessage(ctx, chatID, messageID, h.t("rights_last_admin", user))
+				return
+			}
+			h.editMessage(ctx, chatID, messageID, h.tParams("error_update_rights", user, map[string]string{"error": err.Error()}))
+			return
+		}
+	}
+	h.audit(ctx, user, models.AuditRoleRequest, requester.GetDisplayName(), role.Name+": "+request.Reason, string(request.Status))
+
+	if !approve {
+		h.sendMessage(ctx, requester.TelegramID, h.tParams("role_request_denied_notice", requester, map[string]string{
+			"rights": h.menu.RoleName(role, h.getUserLang(requester)),
+		}))
+		h.editMessage(ctx, chatID, messageID, h.tParams("role_request_denied", user, map[string]string{
+			"user":   requester.GetDisplayName(),
+			"rights": h.menu.RoleName(role, h.getUserLang(user)),
+		}))
+		return
+	}
+	h.editMessage(ctx, chatID, messageID, h.tParams("rights_updated", user, map[string]string{
+		"user":   requester.GetDisplayName(),
+		"rights": h.menu.RoleName(role, h.getUserLang(user)),
+	}))
+}
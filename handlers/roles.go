@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func permissionList(role *models.Role) string {
+	permissions := make([]string, len(role.Permissions))
+	for i, permission := range role.Permissions {
+		permissions[i] = string(permission)
+	}
+	return strings.Join(permissions, ",")
+}
+
+func (h *BotHandlers) getPermissionName(permission models.Permission, user *models.User) string {
+	return h.t("perm_"+strings.ReplaceAll(string(permission), ".", "_"), user)
+}
+
+func (h *BotHandlers) handleRolesMenu(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, role := range roles {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.menu.RoleName(&role, h.getUserLang(user)), fmt.Sprintf("role_%d", role.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_new", user), "role_new"),
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("roles_header", user), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleRoleView(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	roleID, err := strconv.ParseInt(strings.TrimPrefix(data, "role_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	h.showRole(ctx, chatID, messageID, roleID, user)
+}
+
+func (h *BotHandlers) showRole(ctx context.Context, chatID int64, messageID int, roleID int64, user *models.User) {
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("role_not_found", user))
+		return
+	}
+
+	text := h.tParams("role_info", user, map[string]string{
+		"name": h.menu.RoleName(role, h.getUserLang(user)),
+	})
+	if role.BuiltIn {
+		text += h.t("role_built_in", user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, permission := range models.AllPermissions {
+		mark := "⬜"
+		if role.Has(permission) {
+			mark = "✅"
+		}
+		if role.BuiltIn {
+			text += fmt.Sprintf("\n%s %s", mark, h.getPermissionName(permission, user))
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				mark+" "+h.getPermissionName(permission, user),
+				fmt.Sprintf("roleperm_%d_%s", role.ID, permission),
+			),
+		))
+	}
+	if !role.BuiltIn {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_delete", user), fmt.Sprintf("roledel_%d", role.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "roles"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleRolePermissionToggle(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.SplitN(strings.TrimPrefix(data, "roleperm_"), "_", 2)
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	roleID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	before, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("role_not_found", user))
+		return
+	}
+
+	role, err := h.roleService.TogglePermission(roleID, models.Permission(parts[1]))
+	if err != nil {
+		switch err {
+		case models.ErrRoleBuiltIn:
+			h.editMessage(ctx, chatID, messageID, h.t("role_built_in_locked", user))
+		case models.ErrLastAdmin:
+			h.editMessage(ctx, chatID, messageID, h.t("rights_last_admin", user))
+		case models.ErrInvalidPermission:
+			h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		default:
+			slog.ErrorContext(ctx, "Error updating role", "role_id", roleID, "error", err)
+			h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		}
+		return
+	}
+
+	h.audit(ctx, user, models.AuditRoleUpdate, role.Name, permissionList(before), permissionList(role))
+	h.showRole(ctx, chatID, messageID, roleID, user)
+}
+
+func (h *BotHandlers) handleRoleDelete(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	roleID, err := strconv.ParseInt(strings.TrimPrefix(data, "roledel_"), 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err == nil {
+		err = h.roleService.DeleteRole(roleID)
+	}
+	if err != nil {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "roles"),
+			),
+		)
+		switch err {
+		case models.ErrRoleNotFound:
+			h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_not_found", user), keyboard)
+		case models.ErrRoleBuiltIn:
+			h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_built_in_locked", user), keyboard)
+		case models.ErrRoleInUse:
+			h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("role_in_use", user), keyboard)
+		default:
+			slog.ErrorContext(ctx, "Error deleting role", "role_id", roleID, "error", err)
+			h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("error_generic", user), keyboard)
+		}
+		return
+	}
+
+	h.audit(ctx, user, models.AuditRoleUpdate, role.Name, permissionList(role), "")
+	h.handleRolesMenu(ctx, chatID, messageID, user)
+}
+
+func (h *BotHandlers) handleRoleCreatePrompt(ctx context.Context, userID, chatID int64, messageID int, user *models.User) {
+	h.setUserState(userID, "waiting_role_name", nil)
+	h.editMessage(ctx, chatID, messageID, h.t("role_name_prompt", user))
+}
+
+func (h *BotHandlers) handleRoleCreate(ctx context.Context, chatID int64, user *models.User, name string) {
+	role, err := h.roleService.CreateRole(name)
+	if err != nil {
+		switch err {
+		case models.ErrInvalidRoleName:
+			h.sendMessage(ctx, chatID, h.t("role_name_invalid", user))
+		case models.ErrDuplicateRole:
+			h.sendMessage(ctx, chatID, h.t("role_exists", user))
+		default:
+			slog.ErrorContext(ctx, "Error creating role", "error", err)
+			h.sendMessage(ctx, chatID, h.t("error_generic", user))
+		}
+		h.sendMainMenu(ctx, chatID, user)
+		return
+	}
+
+	h.audit(ctx, user, models.AuditRoleUpdate, role.Name, "", permissionList(role))
+
+	msg := tgbotapi.NewMessage(chatID, h.tParams("role_created", user, map[string]string{"name": role.Name}))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_role_edit", user), fmt.Sprintf("role_%d", role.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+		),
+	)
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending role confirmation", "error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// confirmationPause is how long a confirmation stays on screen before the
+// menu replaces it.
+const confirmationPause = 2 * time.Second
+
+// goBackground runs fn in a goroutine that Shutdown waits for.
+func (h *BotHandlers) goBackground(fn func()) {
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		fn()
+	}()
+}
+
+// pause waits for confirmationPause, or less if the bot is shutting down.
+func (h *BotHandlers) pause() {
+	timer := time.NewTimer(confirmationPause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-h.done:
+	}
+}
+
+// later runs fn in the background after a pause, so that the user can read
+// the confirmation first.
+func (h *BotHandlers) later(fn func()) {
+	h.goBackground(func() {
+		h.pause()
+		fn()
+	})
+}
+
+// Shutdown lets the workers handle the updates already queued and waits for
+// background sends such as open notifications, cutting pauses short. The
+// update source must be stopped first. It gives up when ctx is done.
+func (h *BotHandlers) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() { close(h.done) })
+
+	finished := make(chan struct{})
+	go func() {
+		h.updates.stop()
+		h.background.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
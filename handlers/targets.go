@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"lunobot/models"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// userPickerRequestID identifies the user picker shown when assigning roles.
+const userPickerRequestID = 1
+
+// The bundled Telegram library predates KeyboardButtonRequestUsers, so the
+// picker button and the users_shared reply are declared here.
+type requestUsersButton struct {
+	Text         string                     `json:"text"`
+	RequestUsers keyboardButtonRequestUsers `json:"request_users"`
+}
+
+type keyboardButtonRequestUsers struct {
+	RequestID   int  `json:"request_id"`
+	UserIsBot   bool `json:"user_is_bot"`
+	MaxQuantity int  `json:"max_quantity"`
+}
+
+type replyKeyboard struct {
+	Keyboard        [][]interface{} `json:"keyboard"`
+	ResizeKeyboard  bool            `json:"resize_keyboard"`
+	OneTimeKeyboard bool            `json:"one_time_keyboard"`
+}
+
+type usersSharedUpdate struct {
+	Message *struct {
+		UsersShared *struct {
+			RequestID int `json:"request_id"`
+			Users     []struct {
+				UserID int64 `json:"user_id"`
+			} `json:"users"`
+		} `json:"users_shared"`
+	} `json:"message"`
+}
+
+func copyPickedUser(update *tgbotapi.Update, shared *usersSharedUpdate) {
+	if update.Message == nil || shared.Message == nil {
+		return
+	}
+	picked := shared.Message.UsersShared
+	if picked == nil || picked.RequestID != userPickerRequestID || len(picked.Users) == 0 {
+		return
+	}
+	update.Message.Contact = &tgbotapi.Contact{UserID: picked.Users[0].UserID}
+}
+
+func (h *BotHandlers) targetPickerKeyboard(user *models.User) replyKeyboard {
+	return replyKeyboard{
+		Keyboard: [][]interface{}{{
+			requestUsersButton{
+				Text: h.t("btn_pick_user", user),
+				RequestUsers: keyboardButtonRequestUsers{
+					RequestID:   userPickerRequestID,
+					MaxQuantity: 1,
+				},
+			},
+		}},
+		ResizeKeyboard:  true,
+		OneTimeKeyboard: true,
+	}
+}
+
+// resolveTargetUser finds the user an admin pointed at with a forwarded
+// message, a shared contact or the user picker, a numeric Telegram ID, or a
+// username. On failure it returns the translation key of the error.
+func (h *BotHandlers) resolveTargetUser(ctx context.Context, message *tgbotapi.Message) (*models.User, string) {
+	var targetID int64
+	switch {
+	case message.ForwardFrom != nil:
+		if message.ForwardFrom.IsBot {
+			return nil, "target_is_bot"
+		}
+		targetID = message.ForwardFrom.ID
+	case message.ForwardSenderName != "":
+		return nil, "target_forward_hidden"
+	case message.Contact != nil:
+		if message.Contact.UserID == 0 {
+			return nil, "target_contact_no_account"
+		}
+		targetID = message.Contact.UserID
+	default:
+		text := strings.TrimSpace(message.Text)
+		if text == "" {
+			return nil, "target_invalid"
+		}
+		if id, err := strconv.ParseInt(text, 10, 64); err == nil {
+			targetID = id
+			break
+		}
+		target, err := h.userService.GetUserByUsername(text)
+		if err == models.ErrUserNotFound {
+			return nil, "user_not_found"
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error finding user", "query", text, "error", err)
+			return nil, "error_generic"
+		}
+		targetID = target.TelegramID
+	}
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err == models.ErrUserNotFound {
+		return nil, "user_not_found"
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error finding user", "target_id", targetID, "error", err)
+		return nil, "error_generic"
+	}
+	return target, ""
+}
+
+func (h *BotHandlers) handleRightsTarget(ctx context.Context, message *tgbotapi.Message, state *UserState, user *models.User) {
+	chatID := message.Chat.ID
+	roleID, ok := state.Data["role_id"].(int64)
+	if !ok {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("error_data_processing", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.clearUserState(user.TelegramID)
+		h.sendMessage(ctx, chatID, h.t("error_invalid_rights", user))
+		return
+	}
+
+	target, errKey := h.resolveTargetUser(ctx, message)
+	if errKey == "target_invalid" {
+		h.sendMessage(ctx, chatID, h.t(errKey, user))
+		return
+	}
+	h.clearUserState(user.TelegramID)
+
+	var text string
+	var confirm *tgbotapi.InlineKeyboardMarkup
+	if errKey != "" {
+		text = h.t(errKey, user)
+	} else {
+		expiresAt, _ := state.Data["expires_at"].(time.Time)
+		text, confirm = h.assignRole(ctx, user, target, role, expiresAt, false)
+	}
+
+	if confirm != nil {
+		// The reply keyboard can only be removed by a message of its own.
+		h.sendWithoutReplyKeyboard(ctx, chatID, h.tParams("target_selected", user, map[string]string{"user": target.GetDisplayName()}))
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = *confirm
+		if _, err := h.bot.Send(msg); err != nil {
+			slog.WarnContext(ctx, "Error sending message", "error", err)
+		}
+		return
+	}
+
+	h.sendWithoutReplyKeyboard(ctx, chatID, text)
+	h.sendMainMenu(ctx, chatID, user)
+}
+
+func (h *BotHandlers) sendWithoutReplyKeyboard(ctx context.Context, chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.WarnContext(ctx, "Error sending message", "error", err)
+	}
+}
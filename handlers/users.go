@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/i18n"
+	"lunobot/models"
+	"lunobot/services"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// userDirectoryFilters are the fixed directory filters, in display order.
+// Role filters ("role-<id>") are added from the roles table.
+var userDirectoryFilters = []string{"all", "lang-ua", "lang-en", "notif-on", "notif-off", "active-7", "inactive-30"}
+
+func parseUserFilter(key string) (models.UserFilter, bool) {
+	var filter models.UserFilter
+	if key == "all" {
+		return filter, true
+	}
+
+	kind, value, ok := strings.Cut(key, "-")
+	if !ok {
+		return filter, false
+	}
+	switch kind {
+	case "role":
+		roleID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, false
+		}
+		filter.RoleID = roleID
+	case "lang":
+		filter.Language = value
+	case "notif":
+		enabled := value == "on"
+		filter.Notifications = &enabled
+	case "active", "inactive":
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return filter, false
+		}
+		since := time.Now().AddDate(0, 0, -days)
+		if kind == "active" {
+			filter.ActiveSince = since
+		} else {
+			filter.InactiveSince = since
+		}
+	default:
+		return filter, false
+	}
+	return filter, true
+}
+
+func (h *BotHandlers) getUserFilterName(key string, user *models.User) string {
+	if roleID, ok := strings.CutPrefix(key, "role-"); ok {
+		id, err := strconv.ParseInt(roleID, 10, 64)
+		if err == nil {
+			if role, err := h.roleService.GetRoleByID(id); err == nil {
+				return "🧩 " + h.menu.RoleName(role, h.getUserLang(user))
+			}
+		}
+		return key
+	}
+	return h.t("users_filter_"+strings.ReplaceAll(key, "-", "_"), user)
+}
+
+func (h *BotHandlers) handleUserDirectory(ctx context.Context, chatID int64, messageID int, user *models.User) {
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	filters := append([]string{}, userDirectoryFilters[:1]...)
+	for _, role := range roles {
+		filters = append(filters, fmt.Sprintf("role-%d", role.ID))
+	}
+	filters = append(filters, userDirectoryFilters[1:]...)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(filters); i += 2 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, filter := range filters[i:min(i+2, len(filters))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+				h.getUserFilterName(filter, user), fmt.Sprintf("users_%s_1", filter),
+			))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_approvals", user), "approvals"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "back_to_menu"),
+		),
+	)
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("users_menu_header", user), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleUserList(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "users_"), "_")
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	filterKey := parts[0]
+	filter, ok := parseUserFilter(filterKey)
+	if !ok {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	users, totalPages, err := h.userService.GetUsers(filter, page)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting users", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	if len(users) == 0 {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "user_directory"),
+			),
+		)
+		h.editMessageWithKeyboard(ctx, chatID, messageID, h.t("users_empty", user), keyboard)
+		return
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	text := h.tParams("users_header", user, map[string]string{
+		"filter":      h.getUserFilterName(filterKey, user),
+		"page":        strconv.Itoa(page),
+		"total_pages": strconv.Itoa(totalPages),
+	})
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, u := range users {
+		label := u.GetDisplayName()
+		if u.Banned {
+			label = "🚫 " + label
+		} else if u.Muted {
+			label = "🔇 " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s · %s", label, h.localTime(u.LastSeenAt).Format("02.01.2006")),
+				fmt.Sprintf("user_%d", u.TelegramID),
+			),
+		))
+	}
+
+	var navRow []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("users_%s_%d", filterKey, page-1)))
+	}
+	if page < totalPages {
+		navRow = append(navRow, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("users_%s_%d", filterKey, page+1)))
+	}
+	if len(navRow) > 0 {
+		rows = append(rows, navRow)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "user_directory"),
+	))
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func parseTargetID(data, prefix string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(data, prefix), 10, 64)
+}
+
+func (h *BotHandlers) handleUserProfile(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	targetID, err := parseTargetID(data, "user_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	h.showUserProfile(ctx, chatID, messageID, targetID, user)
+}
+
+func (h *BotHandlers) showUserProfile(ctx context.Context, chatID int64, messageID int, targetID int64, user *models.User) {
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+
+	notifications := h.t("users_notifications_off", user)
+	if target.NotificationsEnabled {
+		notifications = h.t("users_notifications_on", user)
+	}
+	text := h.tParams("user_profile", user, map[string]string{
+		"name":          target.GetDisplayName(),
+		"id":            strconv.FormatInt(target.TelegramID, 10),
+		"role":          h.getUserRoleName(target, user),
+		"language":      h.translator.Get("language_name", i18n.ParseLanguage(target.Language)),
+		"notifications": notifications,
+		"joined":        h.localTime(target.CreatedAt).Format("02.01.2006"),
+		"last_seen":     h.localTime(target.LastSeenAt).Format("02.01.2006 15:04"),
+	})
+	if !target.RoleExpiresAt.IsZero() {
+		text += "\n" + h.tParams("user_profile_role_expires", user, map[string]string{
+			"until": h.localTime(target.RoleExpiresAt).Format("02.01.2006 15:04"),
+		})
+	}
+	if target.Membership != models.MembershipActive {
+		text += "\n" + h.t("user_profile_"+string(target.Membership), user)
+	}
+	if target.Banned || target.Muted {
+		text += "\n" + h.formatRestriction(target, user)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if target.Membership != models.MembershipActive {
+		rows = append(rows, h.membershipButtons(target, user))
+	}
+	if user.HasPermission(models.PermRightsManage) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_user_role", user), fmt.Sprintf("userrole_%d", target.TelegramID)),
+		))
+	}
+	banText, muteText := h.t("btn_user_ban", user), h.t("btn_user_mute", user)
+	if target.Banned {
+		banText = h.t("btn_user_unban", user)
+	}
+	if target.Muted {
+		muteText = h.t("btn_user_unmute", user)
+	}
+	restrictRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(banText, fmt.Sprintf("userban_%d", target.TelegramID)),
+	)
+	if !target.Banned {
+		restrictRow = append(restrictRow, tgbotapi.NewInlineKeyboardButtonData(muteText, fmt.Sprintf("usermute_%d", target.TelegramID)))
+	}
+	rows = append(rows, restrictRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_user_message", user), fmt.Sprintf("usermsg_%d", target.TelegramID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), "user_directory"),
+		),
+	)
+
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// getUserRoleName returns the name of target's role in viewer's language.
+func (h *BotHandlers) getUserRoleName(target, viewer *models.User) string {
+	if target.Role != nil {
+		return h.menu.RoleName(target.Role, h.getUserLang(viewer))
+	}
+	return h.t("rights_"+models.BuiltInRoleForRights(target.Rights), viewer)
+}
+
+func (h *BotHandlers) handleUserRoleSelect(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	targetID, err := parseTargetID(data, "userrole_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting roles", "error", err)
+		h.editMessage(ctx, chatID, messageID, h.t("error_generic", user))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, role := range roles {
+		label := h.menu.RoleName(&role, h.getUserLang(user))
+		if role.ID == target.RoleID {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("userroleset_%d_%d", targetID, role.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), fmt.Sprintf("user_%d", targetID)),
+	))
+
+	text := h.tParams("user_role_select", user, map[string]string{"user": target.GetDisplayName()})
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func (h *BotHandlers) handleUserRoleUpdate(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "userroleset_"), "_")
+	if len(parts) != 2 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	targetID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	roleID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_invalid_rights", user))
+		return
+	}
+
+	text, confirm := h.assignRole(ctx, user, target, role, time.Time{}, false)
+	if confirm != nil {
+		h.editMessageWithKeyboard(ctx, chatID, messageID, text, *confirm)
+		return
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.t("btn_back", user), fmt.Sprintf("user_%d", targetID)),
+		),
+	)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, keyboard)
+}
+
+// assignRole gives the target the role, until expiresAt unless it is zero,
+// and returns the text to show. Taking rights management away from an admin
+// must be confirmed first, in which case nothing changes and a confirmation
+// keyboard is returned as well.
+func (h *BotHandlers) assignRole(ctx context.Context, user, target *models.User, role *models.Role, expiresAt time.Time, confirmed bool) (string, *tgbotapi.InlineKeyboardMarkup) {
+	roleName := h.menu.RoleName(role, h.getUserLang(user))
+	if !confirmed && services.IsAdminDemotion(target, role) {
+		var expiresUnix int64
+		if !expiresAt.IsZero() {
+			expiresUnix = expiresAt.Unix()
+		}
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_confirm", user), fmt.Sprintf("rightsconfirm_%d_%d_%d", target.TelegramID, role.ID, expiresUnix)),
+				tgbotapi.NewInlineKeyboardButtonData(h.t("btn_cancel", user), "rightscancel"),
+			),
+		)
+		return h.tParams("rights_demote_confirm", user, map[string]string{
+			"user":   target.GetDisplayName(),
+			"rights": roleName,
+		}), &keyboard
+	}
+
+	if err := h.ChangeRole(ctx, user, target, role, expiresAt); err != nil {
+		if err == models.ErrLastAdmin {
+			return h.t("rights_last_admin", user), nil
+		}
+		return h.tParams("error_update_rights", user, map[string]string{"error": err.Error()}), nil
+	}
+
+	suffix := ""
+	if !expiresAt.IsZero() {
+		suffix = "_until"
+	}
+	return h.tParams("rights_updated"+suffix, user, map[string]string{
+		"user":   target.GetDisplayName(),
+		"rights": roleName,
+		"until":  h.localTime(expiresAt).Format("02.01.2006 15:04"),
+	}), nil
+}
+
+func (h *BotHandlers) handleRightsConfirm(ctx context.Context, data string, chatID int64, messageID int, user *models.User) {
+	parts := strings.Split(strings.TrimPrefix(data, "rightsconfirm_"), "_")
+	if len(parts) != 3 {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	targetID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	roleID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+	role, err := h.roleService.GetRoleByID(roleID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_invalid_rights", user))
+		return
+	}
+	var expiresAt time.Time
+	if expiresUnix, err := strconv.ParseInt(parts[2], 10, 64); err == nil && expiresUnix > 0 {
+		expiresAt = time.Unix(expiresUnix, 0)
+	}
+
+	text, _ := h.assignRole(ctx, user, target, role, expiresAt, true)
+	h.editMessageWithKeyboard(ctx, chatID, messageID, text, h.backToMenuKeyboard(user))
+}
+
+func (h *BotHandlers) handleUserMessagePrompt(ctx context.Context, data string, userID, chatID int64, messageID int, user *models.User) {
+	targetID, err := parseTargetID(data, "usermsg_")
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("error_data_processing", user))
+		return
+	}
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.editMessage(ctx, chatID, messageID, h.t("user_not_found", user))
+		return
+	}
+
+	h.setUserState(userID, "waiting_user_message", map[string]interface{}{
+		"target_id": targetID,
+	})
+	h.editMessage(ctx, chatID, messageID, h.tParams("user_message_prompt", user, map[string]string{"user": target.GetDisplayName()}))
+}
+
+func (h *BotHandlers) handleUserMessageSend(ctx context.Context, chatID int64, user *models.User, state *UserState, text string) {
+	targetID, ok := state.Data["target_id"].(int64)
+	if !ok {
+		h.sendMessage(ctx, chatID, h.t("error_data_processing", user))
+		return
+	}
+	target, err := h.userService.GetUserByTelegramID(targetID)
+	if err != nil {
+		h.sendMessage(ctx, chatID, h.t("user_not_found", user))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(target.TelegramID, h.tParams("user_message_received", target, map[string]string{"text": text}))
+	if _, err := h.bot.Send(msg); err != nil {
+		slog.WarnContext(ctx, "Error sending message to user", "target_id", target.TelegramID, "error", err)
+		h.sendMessage(ctx, chatID, h.t("user_message_failed", user))
+		return
+	}
+
+	h.audit(ctx, user, models.AuditUserMessage, target.GetDisplayName(), "", text)
+	h.sendMessage(ctx, chatID, h.tParams("user_message_sent", user, map[string]string{"user": target.GetDisplayName()}))
+}
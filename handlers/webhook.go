@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"lunobot/health"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxWebhookBodySize = 1 << 20
+
+// webhookQueueWait is how long an update waits for room in a full worker
+// queue before Telegram is told to send it again later.
+const webhookQueueWait = 10 * time.Second
+
+// StartWebhook registers webhookURL with Telegram and handles the updates
+// posted to listen until ctx is done. TLS is expected to be terminated by a
+// reverse proxy in front of the bot. Telegram sends secret with every update
+// and requests without it are rejected, so secret must not be empty. If
+// probes is not nil, its /healthz and /readyz are served on listen too. Once
+// ctx is done, the requests in flight are waited for until shutdownCtx is.
+func (h *BotHandlers) StartWebhook(ctx, shutdownCtx context.Context, listen, webhookURL, secret string, probes *health.Checker) error {
+	endpoint, err := url.Parse(webhookURL)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("webhook secret is required")
+	}
+	path := endpoint.Path
+	if path == "" {
+		path = "/"
+	}
+
+	params := tgbotapi.Params{"url": webhookURL}
+	params.AddNonEmpty("secret_token", secret)
+	if _, err := h.bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		update, err := decodeUpdate(body)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Error decoding webhook update", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		dispatchCtx, cancel := context.WithTimeout(r.Context(), webhookQueueWait)
+		defer cancel()
+		defer context.AfterFunc(ctx, cancel)()
+		if !h.updates.dispatch(dispatchCtx, update) {
+			slog.WarnContext(r.Context(), "Worker queue is full, asking Telegram to resend update", "update_id", update.UpdateID)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if probes != nil {
+		probes.Register(mux)
+	}
+
+	server := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error stopping webhook server", "error", err)
+		}
+	}()
+
+	slog.Info("Listening for webhook updates", "listen", listen, "path", path)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	// Wait for the requests in flight to hand over their updates.
+	<-stopped
+	slog.Info("Bot stopped")
+	return nil
+}
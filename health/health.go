@@ -0,0 +1,109 @@
+// Package health serves liveness and readiness probes for supervisors.
+package health
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds how long /readyz waits for a single check.
+const checkTimeout = 5 * time.Second
+
+// Check reports on one dependency. The details it returns, such as the time
+// of the last tick, are shown in /readyz whether or not the check fails.
+type Check func() (map[string]interface{}, error)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	checks []namedCheck
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a readiness check. It must be called before Register.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Register serves /healthz and /readyz on mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", c.healthz)
+	mux.HandleFunc("GET /readyz", c.readyz)
+}
+
+// healthz answers as long as the process can serve requests.
+func (c *Checker) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz runs every check at once and fails if any of them does.
+func (c *Checker) readyz(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]map[string]interface{}, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := run(nc.check)
+			mu.Lock()
+			results[nc.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	code, status := http.StatusOK, "ready"
+	for _, result := range results {
+		if result["ok"] != true {
+			code, status = http.StatusServiceUnavailable, "not_ready"
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": results})
+}
+
+func run(check Check) map[string]interface{} {
+	type outcome struct {
+		details map[string]interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := check()
+		done <- outcome{details, err}
+	}()
+
+	result := map[string]interface{}{}
+	select {
+	case o := <-done:
+		for key, value := range o.details {
+			result[key] = value
+		}
+		result["ok"] = o.err == nil
+		if o.err != nil {
+			result["error"] = o.err.Error()
+		}
+	case <-time.After(checkTimeout):
+		result["ok"] = false
+		result["error"] = "check timed out"
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing health response", "error", err)
+	}
+}
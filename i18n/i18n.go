@@ -2,7 +2,7 @@ package i18n
 
 import (
 	"embed"
-	"log"
+	"log/slog"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -59,13 +59,13 @@ func (t *Translator) loadTranslations() {
 	for _, l := range languages {
 		data, err := translationFiles.ReadFile(l.file)
 		if err != nil {
-			log.Printf("Failed to load translation file %s: %v", l.file, err)
+			slog.Error("Failed to load translation file", "file", l.file, "error", err)
 			continue
 		}
 
 		var translations map[string]string
 		if err := yaml.Unmarshal(data, &translations); err != nil {
-			log.Printf("Failed to parse translation file %s: %v", l.file, err)
+			slog.Error("Failed to parse translation file", "file", l.file, "error", err)
 			continue
 		}
 
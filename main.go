@@ -2,53 +2,105 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"lunobot/api"
 	"lunobot/config"
 	"lunobot/database"
 	"lunobot/handlers"
+	"lunobot/health"
+	"lunobot/logging"
+	"lunobot/metrics"
 	"lunobot/services"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 func main() {
-	cfg := config.Load()
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN environment variable is required")
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Level, cfg.LogFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	db, err := database.NewDB(cfg.DatabasePath)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
-			log.Printf("Failed to close database: %v", err)
+			slog.Error("Failed to close database", "error", err)
 		}
 	}()
 
-	bot, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
+	bot, err := tgbotapi.NewBotAPIWithClient(cfg.TelegramToken, tgbotapi.APIEndpoint, &metrics.TelegramClient{Client: &http.Client{}})
 	if err != nil {
-		log.Fatal("Failed to create bot:", err)
+		slog.Error("Failed to create bot", "error", err)
+		os.Exit(1)
 	}
-	bot.Debug = cfg.Debug
 
-	log.Printf("Bot %s started successfully!", bot.Self.UserName)
+	tgbotapi.SetLogger(slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn))
 
-	userService := services.NewUserService(db)
-	ideaService := services.NewIdeaService(db)
+	slog.Info("Bot started successfully", "username", bot.Self.UserName)
+
+	userService := services.NewUserService(db, cfg.UsersPerPage)
+	ideaService := services.NewIdeaService(db, services.IdeaLimits{
+		PerHour:     cfg.IdeasPerHour,
+		PerDay:      cfg.IdeasPerDay,
+		BannedWords: cfg.IdeasBannedWords,
+	})
 	statusService := services.NewStatusService(db)
 	broadcastService := services.NewBroadcastService(db, bot)
-	schedulerService := services.NewSchedulerService(db, statusService, broadcastService)
-	logService := services.NewLogService()
+	schedulerService := services.NewSchedulerService(db, statusService, broadcastService, cfg.SchedulerTick, cfg.Location)
+	logService := services.NewLogService(cfg.LogDir, cfg.EntriesPerPage, cfg.Location)
+	auditService := services.NewAuditService(db, cfg.EntriesPerPage)
+	roleService := services.NewRoleService(db)
+	inviteService := services.NewInviteService(db)
+	roleRequestService := services.NewRoleRequestService(db)
+
+	var webhookEndpoints []services.WebhookEndpoint
+	for _, webhook := range cfg.OutgoingWebhooks {
+		webhookEndpoints = append(webhookEndpoints, services.WebhookEndpoint{
+			URL:    webhook.URL,
+			Secret: webhook.Secret,
+			Events: webhook.Events,
+		})
+	}
+	webhookService := services.NewWebhookService(db, webhookEndpoints, cfg.EntriesPerPage)
+
+	if err := bootstrapAdmins(logging.NewCorrelation(context.Background()), cfg.AdminIDs, userService, roleService, auditService); err != nil {
+		slog.Error("Failed to bootstrap admins", "error", err)
+	}
+
+	botHandlers := handlers.NewBotHandlers(bot, userService, ideaService, statusService, broadcastService, schedulerService, logService, auditService, roleService, inviteService, roleRequestService, webhookService, cfg.StateTTL, cfg.UpdateWorkers, cfg.UpdateQueueSize, cfg.Location)
 
-	botHandlers := handlers.NewBotHandlers(bot, userService, ideaService, statusService, broadcastService, schedulerService, logService)
+	if cfg.ClosedMembership {
+		userService.SetClosedMembership(botHandlers.NotifyPendingUser)
+	}
 
+	schedulerService.SetIdeaDigest(cfg.IdeaDigestTime, botHandlers.SendIdeaDigest)
+	schedulerService.SetRoleExpiry(botHandlers.RevokeExpiredRoles)
+	schedulerService.SetRestrictionExpiry(botHandlers.LiftExpiredRestrictions)
+	schedulerService.SetAutoCloseHandler(botHandlers.AutoClosed)
 	schedulerService.Start()
+	if err := webhookService.ResumePending(context.Background()); err != nil {
+		slog.Error("Failed to resume pending webhook deliveries", "error", err)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -57,10 +109,93 @@ func main() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		<-c
-		log.Println("Shutting down bot...")
-		schedulerService.Stop()
+		slog.Info("Shutting down bot")
 		cancel()
 	}()
 
-	botHandlers.Start(ctx)
+	// Everything accepted before ctx is done gets one shutdown_timeout to
+	// finish, counted from then.
+	shutdownCtx, stop := shutdownContext(ctx, cfg.ShutdownTimeout)
+	defer stop()
+
+	var servers sync.WaitGroup
+
+	// The probes are served by the metrics listener, or else by the first
+	// other listener there is.
+	checker := health.NewChecker()
+	checker.Add("database", func() (map[string]interface{}, error) { return nil, db.Ping() })
+	checker.Add("telegram", botHandlers.CheckTelegram)
+	checker.Add("scheduler", schedulerService.CheckLiveness)
+	checker.Add("outbox", webhookService.CheckBacklog)
+	probes := checker
+
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		checker.Register(mux)
+		probes = nil
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := metrics.Serve(ctx, shutdownCtx, cfg.MetricsListen, mux); err != nil {
+				slog.Error("Metrics server failed", "error", err)
+			}
+		}()
+	}
+
+	if cfg.APIListen != "" {
+		apiServer := api.NewServer(statusService, schedulerService, logService, userService, ideaService, roleService,
+			services.NewAPITokenService(db), botHandlers, cfg.APICORSOrigins, cfg.Location)
+		if cfg.DeviceSecret != "" {
+			apiServer.EnableDeviceEvents(cfg.DeviceSecret, cfg.DeviceDebounce)
+		}
+		if probes != nil {
+			apiServer.EnableProbes(probes)
+			probes = nil
+		}
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := apiServer.Start(ctx, shutdownCtx, cfg.APIListen); err != nil {
+				slog.Error("API server failed", "error", err)
+			}
+		}()
+	}
+
+	if cfg.Transport == config.TransportWebhook {
+		if err := botHandlers.StartWebhook(ctx, shutdownCtx, cfg.WebhookListen, cfg.WebhookURL, cfg.WebhookSecret, probes); err != nil {
+			slog.Error("Webhook server failed", "error", err)
+		}
+	} else {
+		if probes != nil {
+			slog.Warn("Health probes are not served: set metrics_listen or api_listen to serve /healthz and /readyz")
+		}
+		botHandlers.Start(ctx)
+	}
+
+	// No new work comes in from here on. Let what was accepted finish before
+	// the database is closed.
+	cancel()
+	servers.Wait()
+	if err := schedulerService.Stop(shutdownCtx); err != nil {
+		slog.Warn("Scheduled job did not finish before shutdown", "error", err)
+	}
+	if err := botHandlers.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Updates were still being handled at shutdown", "error", err)
+	}
+	if err := webhookService.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Webhook deliveries were still in progress at shutdown", "error", err)
+	}
+	slog.Info("Shutdown complete")
+}
+
+// shutdownContext returns a context that is done timeout after ctx is.
+func shutdownContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	shutdownCtx, cancel := context.WithCancelCause(context.Background())
+	stopAfter := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	})
+	return shutdownCtx, func() {
+		stopAfter()
+		cancel(context.Canceled)
+	}
 }
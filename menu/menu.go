@@ -1,25 +1,28 @@
 package menu
 
 import (
+	"fmt"
 	"lunobot/i18n"
 	"lunobot/models"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// MenuButton is a main menu entry. Buttons with a Permission are only shown
+// to users whose role grants it.
 type MenuButton struct {
-	TextKey  string
-	Callback string
+	TextKey    string
+	Callback   string
+	Permission models.Permission
 }
 
 type MenuGenerator struct {
-	buttons    map[models.Rights][]MenuButton
+	buttons    []MenuButton
 	translator *i18n.Translator
 }
 
 func NewMenuGenerator(translator *i18n.Translator) *MenuGenerator {
 	mg := &MenuGenerator{
-		buttons:    make(map[models.Rights][]MenuButton),
 		translator: translator,
 	}
 	mg.initializeButtons()
@@ -27,37 +30,29 @@ func NewMenuGenerator(translator *i18n.Translator) *MenuGenerator {
 }
 
 func (mg *MenuGenerator) initializeButtons() {
-	mg.buttons[models.RightsDefault] = []MenuButton{
+	mg.buttons = []MenuButton{
 		{TextKey: "btn_check_status", Callback: "check_status"},
 		{TextKey: "btn_send_idea", Callback: "send_idea"},
 		{TextKey: "btn_notifications", Callback: "notifications"},
 		{TextKey: "btn_language", Callback: "change_language"},
-	}
-	mg.buttons[models.RightsManager] = []MenuButton{
-		{TextKey: "btn_check_status", Callback: "check_status"},
-		{TextKey: "btn_send_idea", Callback: "send_idea"},
-		{TextKey: "btn_notifications", Callback: "notifications"},
-		{TextKey: "btn_language", Callback: "change_language"},
-		{TextKey: "btn_set_open_status", Callback: "set_open_status"},
-		{TextKey: "btn_set_tech_status", Callback: "set_tech_status"},
-	}
-	mg.buttons[models.RightsAdmin] = []MenuButton{
-		{TextKey: "btn_check_status", Callback: "check_status"},
-		{TextKey: "btn_send_idea", Callback: "send_idea"},
-		{TextKey: "btn_notifications", Callback: "notifications"},
-		{TextKey: "btn_language", Callback: "change_language"},
-		{TextKey: "btn_set_open_status", Callback: "set_open_status"},
-		{TextKey: "btn_set_tech_status", Callback: "set_tech_status"},
-		{TextKey: "btn_read_ideas", Callback: "read_ideas"},
-		{TextKey: "btn_set_rights", Callback: "set_rights"},
-		{TextKey: "btn_create_broadcast", Callback: "create_broadcast"},
-		{TextKey: "btn_auto_close", Callback: "auto_close"},
-		{TextKey: "btn_status_logs", Callback: "status_logs"},
+		{TextKey: "btn_request_access", Callback: "request_access"},
+		{TextKey: "btn_set_open_status", Callback: "set_open_status", Permission: models.PermStatusChange},
+		{TextKey: "btn_set_tech_status", Callback: "set_tech_status", Permission: models.PermKeysChange},
+		{TextKey: "btn_read_ideas", Callback: "read_ideas", Permission: models.PermIdeasRead},
+		{TextKey: "btn_idea_alerts", Callback: "idea_alerts", Permission: models.PermIdeasRead},
+		{TextKey: "btn_set_rights", Callback: "set_rights", Permission: models.PermRightsManage},
+		{TextKey: "btn_roles", Callback: "roles", Permission: models.PermRightsManage},
+		{TextKey: "btn_invites", Callback: "invites", Permission: models.PermRightsManage},
+		{TextKey: "btn_users", Callback: "user_directory", Permission: models.PermUsersManage},
+		{TextKey: "btn_create_broadcast", Callback: "create_broadcast", Permission: models.PermBroadcastSend},
+		{TextKey: "btn_auto_close", Callback: "auto_close", Permission: models.PermAutoCloseManage},
+		{TextKey: "btn_status_logs", Callback: "status_logs", Permission: models.PermLogsRead},
+		{TextKey: "btn_audit_log", Callback: "audit_log", Permission: models.PermAuditRead},
 	}
 }
 
-func (mg *MenuGenerator) GenerateKeyboard(userRights models.Rights, lang i18n.Language) tgbotapi.InlineKeyboardMarkup {
-	buttons := mg.getButtonsForRights(userRights)
+func (mg *MenuGenerator) GenerateKeyboard(user *models.User, lang i18n.Language) tgbotapi.InlineKeyboardMarkup {
+	buttons := mg.getButtonsForUser(user)
 	var rows [][]tgbotapi.InlineKeyboardButton
 	for i := 0; i < len(buttons); i += 2 {
 		var row []tgbotapi.InlineKeyboardButton
@@ -77,11 +72,23 @@ func (mg *MenuGenerator) GenerateKeyboard(userRights models.Rights, lang i18n.La
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func (mg *MenuGenerator) getButtonsForRights(rights models.Rights) []MenuButton {
-	if buttons, exists := mg.buttons[rights]; exists {
-		return buttons
+func (mg *MenuGenerator) getButtonsForUser(user *models.User) []MenuButton {
+	var buttons []MenuButton
+	for _, button := range mg.buttons {
+		if button.Permission == "" || user.HasPermission(button.Permission) {
+			buttons = append(buttons, button)
+		}
 	}
-	return mg.buttons[models.RightsDefault]
+	return buttons
+}
+
+// RoleName returns the display name of a role. Built-in roles are
+// translated, custom roles are shown as named.
+func (mg *MenuGenerator) RoleName(role *models.Role, lang i18n.Language) string {
+	if role.BuiltIn {
+		return mg.translator.Get("rights_"+role.Name, lang)
+	}
+	return role.Name
 }
 
 func (mg *MenuGenerator) GenerateLanguageKeyboard() tgbotapi.InlineKeyboardMarkup {
@@ -117,19 +124,23 @@ func (mg *MenuGenerator) GenerateStatusKeyboard(statusType string, lang i18n.Lan
 	)
 }
 
-func (mg *MenuGenerator) GenerateRightsKeyboard(lang i18n.Language) tgbotapi.InlineKeyboardMarkup {
-	return tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(mg.translator.Get("btn_rights_user", lang), "rights_1"),
-			tgbotapi.NewInlineKeyboardButtonData(mg.translator.Get("btn_rights_manager", lang), "rights_2"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(mg.translator.Get("btn_rights_admin", lang), "rights_3"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(mg.translator.Get("btn_back", lang), "back_to_menu"),
-		),
-	)
+func (mg *MenuGenerator) GenerateRightsKeyboard(roles []models.Role, lang i18n.Language) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(roles); i += 2 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, role := range roles[i:min(i+2, len(roles))] {
+			label := "🧩 " + role.Name
+			if role.BuiltIn {
+				label = mg.translator.Get("btn_rights_"+role.Name, lang)
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("rights_%d", role.ID)))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(mg.translator.Get("btn_back", lang), "back_to_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
 func (mg *MenuGenerator) GenerateNotificationsKeyboard(enabled bool, lang i18n.Language) tgbotapi.InlineKeyboardMarkup {
@@ -0,0 +1,26 @@
+package metrics
+
+// The bot's metrics.
+var (
+	UpdatesHandled = NewCounterVec("lunobot_updates_total",
+		"Telegram updates handled, by update type.", "type")
+	UpdateQueueDepth = NewGaugeFunc("lunobot_update_queue_depth",
+		"Updates waiting for a worker.")
+	UpdatesRejected = NewCounterVec("lunobot_updates_rejected_total",
+		"Updates given up on because their worker's queue stayed full.")
+	CallbackDuration = NewHistogramVec("lunobot_callback_duration_seconds",
+		"Time taken to handle a callback query, by route.", DefBuckets, "route")
+	TelegramErrors = NewCounterVec("lunobot_telegram_errors_total",
+		"Failed Telegram Bot API requests, by method and error code.", "method", "code")
+	BroadcastDeliveries = NewCounterVec("lunobot_broadcast_deliveries_total",
+		"Broadcast messages sent to users, by kind and result.", "kind", "result")
+	SchedulerRuns = NewCounterVec("lunobot_scheduler_job_runs_total",
+		"Scheduler job runs, by job.", "job")
+	SchedulerFailures = NewCounterVec("lunobot_scheduler_job_failures_total",
+		"Scheduler job runs that failed, by job.", "job")
+	DBQueryDuration = NewHistogramVec("lunobot_db_query_duration_seconds",
+		"Time taken by database statements, by statement kind.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "statement")
+	ConversationStates = NewGaugeFunc("lunobot_conversation_states",
+		"Users in the middle of a multi-step conversation.")
+)
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// TelegramClient is an HTTP client for the Bot API that counts failed
+// requests by method and the error code Telegram returned.
+type TelegramClient struct {
+	Client *http.Client
+}
+
+func (c *TelegramClient) Do(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		TelegramErrors.Inc(method, "network")
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		TelegramErrors.Inc(method, "network")
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var result struct {
+		OK        bool `json:"ok"`
+		ErrorCode int  `json:"error_code"`
+	}
+	if json.Unmarshal(body, &result) == nil && !result.OK {
+		TelegramErrors.Inc(method, strconv.Itoa(result.ErrorCode))
+	}
+	return resp, nil
+}
@@ -10,19 +10,62 @@ var (
 	ErrIdeaNotFound  = errors.New("idea not found")
 	ErrInvalidRights = errors.New("invalid rights level")
 	ErrDuplicateUser = errors.New("user already exists")
+
+	ErrIdeaRateLimited = errors.New("idea submission limit reached")
+	ErrIdeaDuplicate   = errors.New("idea duplicates a recent submission")
+	ErrIdeaBannedWords = errors.New("idea contains banned words")
+	ErrIdeaTooLong     = errors.New("idea is too long")
+
+	ErrInvalidIdeaStatus = errors.New("invalid idea status")
+
+	ErrRoleNotFound  = errors.New("role not found")
+	ErrRoleBuiltIn   = errors.New("built-in roles cannot be modified")
+	ErrRoleInUse     = errors.New("role is assigned to users")
+	ErrDuplicateRole = errors.New("role already exists")
+
+	ErrLastAdmin = errors.New("at least one user must be able to manage rights")
+
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteExpired  = errors.New("invite expired")
+	ErrInviteUsedUp   = errors.New("invite has no uses left")
+
+	ErrRoleRequestNotFound = errors.New("role request not found")
+	ErrRoleRequestPending  = errors.New("user already has a pending role request")
+	ErrRoleRequestDecided  = errors.New("role request already decided")
+
+	ErrInvalidRoleName   = errors.New("invalid role name")
+	ErrInvalidPermission = errors.New("invalid permission")
+
+	ErrInvalidRoleRequestReason = errors.New("invalid role request reason")
+
+	ErrAPITokenNotFound = errors.New("API token not found")
+	ErrEmptyBroadcast   = errors.New("broadcast text is empty")
+	ErrBroadcastTooLong = errors.New("broadcast text is too long")
 )
 
 type User struct {
-	ID                   int64     `json:"id" db:"id"`
-	TelegramID           int64     `json:"telegram_id" db:"telegram_id"`
-	Username             string    `json:"username" db:"username"`
-	FirstName            string    `json:"first_name" db:"first_name"`
-	LastName             string    `json:"last_name" db:"last_name"`
-	Rights               Rights    `json:"rights" db:"rights"`
-	Language             string    `json:"language" db:"language"`
-	NotificationsEnabled bool      `json:"notifications_enabled" db:"notifications_enabled"`
-	CreatedAt            time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
+	ID                   int64         `json:"id" db:"id"`
+	TelegramID           int64         `json:"telegram_id" db:"telegram_id"`
+	Username             string        `json:"username" db:"username"`
+	FirstName            string        `json:"first_name" db:"first_name"`
+	LastName             string        `json:"last_name" db:"last_name"`
+	Rights               Rights        `json:"rights" db:"rights"`
+	RoleID               int64         `json:"role_id" db:"role_id"`
+	Role                 *Role         `json:"role,omitempty" db:"-"`
+	RoleExpiresAt        time.Time     `json:"role_expires_at,omitempty" db:"role_expires_at"`
+	RoleGrantedBy        int64         `json:"role_granted_by,omitempty" db:"role_granted_by"`
+	PreviousRoleID       int64         `json:"previous_role_id,omitempty" db:"previous_role_id"`
+	Language             string        `json:"language" db:"language"`
+	NotificationsEnabled bool          `json:"notifications_enabled" db:"notifications_enabled"`
+	IdeaAlerts           IdeaAlertMode `json:"idea_alerts" db:"idea_alerts"`
+	Membership           Membership    `json:"membership" db:"membership"`
+	Banned               bool          `json:"banned" db:"banned"`
+	Muted                bool          `json:"muted" db:"muted"`
+	RestrictionReason    string        `json:"restriction_reason,omitempty" db:"restriction_reason"`
+	RestrictedUntil      time.Time     `json:"restricted_until,omitempty" db:"restricted_until"`
+	LastSeenAt           time.Time     `json:"last_seen_at" db:"last_seen_at"`
+	CreatedAt            time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time     `json:"updated_at" db:"updated_at"`
 }
 
 func (u *User) GetDisplayName() string {
@@ -40,16 +83,53 @@ func (u *User) HasRights(required Rights) bool {
 	return u.Rights >= required
 }
 
+// HasPermission reports whether the user's role grants the permission. Users
+// whose role has not been loaded fall back to the built-in role for their
+// rights level.
+func (u *User) HasPermission(permission Permission) bool {
+	if u.Role != nil {
+		return u.Role.Has(permission)
+	}
+	for _, p := range BuiltInRolePermissions[BuiltInRoleForRights(u.Rights)] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Membership tells whether the user may use the bot. Outside closed-membership
+// mode every user is active.
+type Membership string
+
+const (
+	MembershipActive   Membership = "active"
+	MembershipPending  Membership = "pending"
+	MembershipRejected Membership = "rejected"
+)
+
+// UserFilter narrows the user directory. Zero values match everyone.
+type UserFilter struct {
+	RoleID        int64
+	Membership    Membership
+	Language      string
+	Notifications *bool
+	ActiveSince   time.Time
+	InactiveSince time.Time
+}
+
 type Idea struct {
-	ID        int64     `json:"id" db:"id"`
-	UserID    int64     `json:"user_id" db:"user_id"`
-	Username  string    `json:"username" db:"username"`
-	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          int64            `json:"id" db:"id"`
+	UserID      int64            `json:"user_id" db:"user_id"`
+	Username    string           `json:"username" db:"username"`
+	Content     string           `json:"content" db:"content"`
+	Status      IdeaStatus       `json:"status" db:"status"`
+	CreatedAt   time.Time        `json:"created_at" db:"created_at"`
+	Attachments []IdeaAttachment `json:"attachments,omitempty" db:"-"`
 }
 
 func (i *Idea) Validate() error {
-	if i.Content == "" {
+	if i.Content == "" && len(i.Attachments) == 0 {
 		return errors.New("idea content cannot be empty")
 	}
 	if len(i.Content) > 4000 {
@@ -58,6 +138,51 @@ func (i *Idea) Validate() error {
 	return nil
 }
 
+type IdeaStatus string
+
+const (
+	IdeaStatusNew        IdeaStatus = "new"
+	IdeaStatusInProgress IdeaStatus = "in_progress"
+	IdeaStatusDone       IdeaStatus = "done"
+	IdeaStatusRejected   IdeaStatus = "rejected"
+	IdeaStatusArchived   IdeaStatus = "archived"
+)
+
+func (s IdeaStatus) IsValid() bool {
+	switch s {
+	case IdeaStatusNew, IdeaStatusInProgress, IdeaStatusDone, IdeaStatusRejected, IdeaStatusArchived:
+		return true
+	}
+	return false
+}
+
+type IdeaAlertMode string
+
+const (
+	IdeaAlertsOff     IdeaAlertMode = "off"
+	IdeaAlertsInstant IdeaAlertMode = "instant"
+	IdeaAlertsDigest  IdeaAlertMode = "digest"
+)
+
+func (m IdeaAlertMode) IsValid() bool {
+	return m == IdeaAlertsOff || m == IdeaAlertsInstant || m == IdeaAlertsDigest
+}
+
+type AttachmentType string
+
+const (
+	AttachmentPhoto    AttachmentType = "photo"
+	AttachmentDocument AttachmentType = "document"
+)
+
+type IdeaAttachment struct {
+	ID       int64          `json:"id" db:"id"`
+	IdeaID   int64          `json:"idea_id" db:"idea_id"`
+	FileID   string         `json:"file_id" db:"file_id"`
+	Type     AttachmentType `json:"type" db:"type"`
+	FileName string         `json:"file_name" db:"file_name"`
+}
+
 type Status struct {
 	ID              int       `json:"id" db:"id"`
 	IsOpen          bool      `json:"is_open" db:"is_open"`
@@ -91,6 +216,138 @@ func (r Rights) String() string {
 	}
 }
 
+type Permission string
+
+const (
+	PermStatusChange    Permission = "status.change"
+	PermKeysChange      Permission = "keys.change"
+	PermIdeasRead       Permission = "ideas.read"
+	PermIdeasManage     Permission = "ideas.manage"
+	PermBroadcastSend   Permission = "broadcast.send"
+	PermRightsManage    Permission = "rights.manage"
+	PermAutoCloseManage Permission = "autoclose.manage"
+	PermLogsRead        Permission = "logs.read"
+	PermAuditRead       Permission = "audit.read"
+	PermUsersManage     Permission = "users.manage"
+)
+
+var AllPermissions = []Permission{
+	PermStatusChange,
+	PermKeysChange,
+	PermIdeasRead,
+	PermIdeasManage,
+	PermBroadcastSend,
+	PermRightsManage,
+	PermAutoCloseManage,
+	PermLogsRead,
+	PermAuditRead,
+	PermUsersManage,
+}
+
+func (p Permission) IsValid() bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	RoleUser    = "user"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+)
+
+// BuiltInRolePermissions maps the built-in roles, which mirror the original
+// three rights levels, to the permissions they grant.
+var BuiltInRolePermissions = map[string][]Permission{
+	RoleUser:    {},
+	RoleManager: {PermStatusChange, PermKeysChange},
+	RoleAdmin:   AllPermissions,
+}
+
+func BuiltInRoleForRights(rights Rights) string {
+	switch rights {
+	case RightsManager:
+		return RoleManager
+	case RightsAdmin:
+		return RoleAdmin
+	default:
+		return RoleUser
+	}
+}
+
+type Role struct {
+	ID          int64        `json:"id" db:"id"`
+	Name        string       `json:"name" db:"name"`
+	BuiltIn     bool         `json:"built_in" db:"built_in"`
+	Permissions []Permission `json:"permissions" db:"-"`
+}
+
+func (r *Role) Has(permission Permission) bool {
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the legacy rights level equivalent to the role. It is kept
+// in the users table so that level-based queries keep working.
+func (r *Role) Level() Rights {
+	switch {
+	case r.Has(PermRightsManage):
+		return RightsAdmin
+	case r.Has(PermStatusChange) || r.Has(PermKeysChange):
+		return RightsManager
+	default:
+		return RightsDefault
+	}
+}
+
+type Invite struct {
+	ID        int64     `json:"id" db:"id"`
+	Code      string    `json:"code" db:"code"`
+	RoleID    int64     `json:"role_id" db:"role_id"`
+	MaxUses   int       `json:"max_uses" db:"max_uses"`
+	Uses      int       `json:"uses" db:"uses"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedBy int64     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// APIToken lets a tool call the admin API on behalf of a user, with that
+// user's permissions. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int64     `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	TelegramID int64     `json:"telegram_id" db:"telegram_id"`
+	TokenHash  string    `json:"-" db:"token_hash"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+type RoleRequestStatus string
+
+const (
+	RoleRequestPending  RoleRequestStatus = "pending"
+	RoleRequestApproved RoleRequestStatus = "approved"
+	RoleRequestDenied   RoleRequestStatus = "denied"
+)
+
+// RoleRequest is a user's request to be given a role, with their reason.
+type RoleRequest struct {
+	ID         int64             `json:"id" db:"id"`
+	TelegramID int64             `json:"telegram_id" db:"telegram_id"`
+	RoleID     int64             `json:"role_id" db:"role_id"`
+	Reason     string            `json:"reason" db:"reason"`
+	Status     RoleRequestStatus `json:"status" db:"status"`
+	DecidedBy  int64             `json:"decided_by,omitempty" db:"decided_by"`
+	CreatedAt  time.Time         `json:"created_at" db:"created_at"`
+}
+
 type AutoCloseSettings struct {
 	ID           int    `json:"id" db:"id"`
 	Enabled      bool   `json:"enabled" db:"enabled"`
@@ -98,3 +355,73 @@ type AutoCloseSettings struct {
 	KeysToLobby  bool   `json:"keys_to_lobby" db:"keys_to_lobby"`
 	LastStatusBy string `json:"last_status_by" db:"last_status_by"`
 }
+
+const (
+	AuditStatusChange    = "status.change"
+	AuditKeysChange      = "keys.change"
+	AuditRightsChange    = "rights.change"
+	AuditIdeaDelete      = "idea.delete"
+	AuditIdeaStatus      = "idea.status"
+	AuditBroadcastSend   = "broadcast.send"
+	AuditAutoCloseUpdate = "autoclose.update"
+	AuditRoleUpdate      = "rights.role"
+	AuditInviteCreate    = "rights.invite_create"
+	AuditInviteRevoke    = "rights.invite_revoke"
+	AuditInviteRedeem    = "rights.invite_redeem"
+	AuditRoleRequest     = "rights.request"
+	AuditTokenCreate     = "rights.token_create"
+	AuditTokenRevoke     = "rights.token_revoke"
+	AuditUserBan         = "user.ban"
+	AuditUserUnban       = "user.unban"
+	AuditUserMute        = "user.mute"
+	AuditUserApprove     = "user.approve"
+	AuditUserReject      = "user.reject"
+	AuditUserUnmute      = "user.unmute"
+	AuditUserMessage     = "user.message"
+)
+
+type AuditEntry struct {
+	ID        int64     `json:"id" db:"id"`
+	ActorID   int64     `json:"actor_id" db:"actor_id"`
+	ActorName string    `json:"actor_name" db:"actor_name"`
+	Action    string    `json:"action" db:"action"`
+	Target    string    `json:"target" db:"target"`
+	Before    string    `json:"before" db:"before_value"`
+	After     string    `json:"after" db:"after_value"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	PrevHash  string    `json:"prev_hash" db:"prev_hash"`
+	Hash      string    `json:"hash" db:"hash"`
+}
+
+// Events sent to outgoing webhooks.
+const (
+	WebhookStatusChanged = "status.changed"
+	WebhookKeysMoved     = "keys.moved"
+	WebhookIdeaCreated   = "idea.created"
+	WebhookAutoClose     = "autoclose.run"
+)
+
+var WebhookEvents = []string{WebhookStatusChanged, WebhookKeysMoved, WebhookIdeaCreated, WebhookAutoClose}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery records one event sent to one webhook URL and how the
+// attempts to deliver it went.
+type WebhookDelivery struct {
+	ID           int64                 `json:"id" db:"id"`
+	URL          string                `json:"url" db:"url"`
+	Event        string                `json:"event" db:"event"`
+	Payload      string                `json:"payload" db:"payload"`
+	Status       WebhookDeliveryStatus `json:"status" db:"status"`
+	Attempts     int                   `json:"attempts" db:"attempts"`
+	ResponseCode int                   `json:"response_code" db:"response_code"`
+	Error        string                `json:"error,omitempty" db:"error"`
+	CreatedAt    time.Time             `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at" db:"updated_at"`
+}
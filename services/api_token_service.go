@@ -0,0 +1,65 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"lunobot/database"
+	"lunobot/models"
+	"time"
+)
+
+// APITokenPrefix starts every admin API token, so that leaked tokens are
+// easy to recognise.
+const APITokenPrefix = "luno_"
+
+type APITokenService struct {
+	db *database.DB
+}
+
+func NewAPITokenService(db *database.DB) *APITokenService {
+	return &APITokenService{db: db}
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken issues a token acting as the user. The token itself is only
+// returned here; the database keeps its hash.
+func (s *APITokenService) CreateToken(telegramID int64, name string) (string, *models.APIToken, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + hex.EncodeToString(secret)
+
+	apiToken := &models.APIToken{
+		Name:       name,
+		TelegramID: telegramID,
+		TokenHash:  hashAPIToken(token),
+	}
+	if err := s.db.CreateAPIToken(apiToken); err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+// Authenticate returns the stored token matching token and records its use.
+func (s *APITokenService) Authenticate(token string) (*models.APIToken, error) {
+	apiToken, err := s.db.GetAPITokenByHash(hashAPIToken(token))
+	if err != nil {
+		return nil, err
+	}
+	apiToken.LastUsedAt = time.Now()
+	return apiToken, s.db.UpdateAPITokenLastUsed(apiToken.ID, apiToken.LastUsedAt)
+}
+
+func (s *APITokenService) GetTokens() ([]models.APIToken, error) {
+	return s.db.GetAPITokens()
+}
+
+func (s *APITokenService) DeleteToken(tokenID int64) error {
+	return s.db.DeleteAPIToken(tokenID)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"lunobot/database"
+	"lunobot/models"
+	"strconv"
+	"time"
+)
+
+// AuditFilters lists the filter names shown in the audit viewer, in display order.
+var AuditFilters = []string{"all", "status", "rights", "users", "ideas", "broadcast", "autoclose"}
+
+var auditFilterPrefixes = map[string][]string{
+	"all":       nil,
+	"status":    {"status.", "keys."},
+	"rights":    {"rights."},
+	"users":     {"user."},
+	"ideas":     {"idea."},
+	"broadcast": {"broadcast."},
+	"autoclose": {"autoclose."},
+}
+
+type AuditService struct {
+	db             *database.DB
+	entriesPerPage int
+}
+
+func NewAuditService(db *database.DB, entriesPerPage int) *AuditService {
+	return &AuditService{db: db, entriesPerPage: entriesPerPage}
+}
+
+func (s *AuditService) Record(ctx context.Context, actor *models.User, action, target, before, after string) error {
+	entry := &models.AuditEntry{
+		ActorID:   actor.TelegramID,
+		ActorName: actor.GetDisplayName(),
+		Action:    action,
+		Target:    target,
+		Before:    before,
+		After:     after,
+		CreatedAt: time.Now(),
+	}
+	err := s.db.AddChainedAuditEntry(entry, func(head string) {
+		if head == "" {
+			head = genesisHash
+		}
+		entry.PrevHash = head
+		entry.Hash = auditEntryHash(entry)
+	})
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Recorded audit entry", "entry_id", entry.ID, "action", action, "actor_id", actor.TelegramID)
+	return nil
+}
+
+func auditEntryHash(entry *models.AuditEntry) string {
+	return chainHash(entry.PrevHash,
+		strconv.FormatInt(entry.ActorID, 10), entry.ActorName, entry.Action, entry.Target,
+		entry.Before, entry.After, strconv.FormatInt(entry.CreatedAt.UnixNano(), 10))
+}
+
+// GetChainHead returns the hash of the most recent audit entry.
+func (s *AuditService) GetChainHead() (string, error) {
+	head, err := s.db.GetLastAuditHash()
+	if err != nil || head != "" {
+		return head, err
+	}
+	return genesisHash, nil
+}
+
+// VerifyChain checks every audit entry's hash link and returns the first
+// broken one, along with the number of entries that verified. Entries
+// recorded before chaining was introduced are skipped as long as they
+// precede the first chained entry.
+func (s *AuditService) VerifyChain() (*ChainBreak, int, error) {
+	entries, err := s.db.GetAuditChain()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	prevHash := genesisHash
+	started := false
+	verified := 0
+	for i := range entries {
+		entry := &entries[i]
+		brokenAt := func(reason string) *ChainBreak {
+			return &ChainBreak{
+				Source:    "audit_log",
+				Record:    int(entry.ID),
+				Timestamp: entry.CreatedAt,
+				Reason:    reason,
+			}
+		}
+		switch {
+		case entry.Hash == "":
+			if started {
+				return brokenAt("entry is not chained"), verified, nil
+			}
+			continue
+		case entry.PrevHash != prevHash:
+			return brokenAt("previous hash mismatch"), verified, nil
+		case auditEntryHash(entry) != entry.Hash:
+			return brokenAt(fmt.Sprintf("hash mismatch for %s", entry.Action)), verified, nil
+		}
+
+		prevHash = entry.Hash
+		started = true
+		verified++
+	}
+
+	return nil, verified, nil
+}
+
+func (s *AuditService) GetEntries(filter string, page int) ([]models.AuditEntry, int, error) {
+	prefixes := auditFilterPrefixes[filter]
+
+	total, err := s.db.CountAuditEntries(prefixes)
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	totalPages := (total + s.entriesPerPage - 1) / s.entriesPerPage
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	entries, err := s.db.GetAuditEntries(prefixes, s.entriesPerPage, (page-1)*s.entriesPerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, totalPages, nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"lunobot/database"
+	"lunobot/models"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestAuditChainAcrossConnections records from two database handles at once,
+// as the bot and a CLI command do, and expects one unbroken chain.
+func TestAuditChainAcrossConnections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.db")
+	var writers []*AuditService
+	for range 2 {
+		db, err := database.NewDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		writers = append(writers, NewAuditService(db, 5))
+	}
+
+	const perWriter = 25
+	actor := &models.User{TelegramID: 1, Username: "admin"}
+	var wg sync.WaitGroup
+	for i, s := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range perWriter {
+				if err := s.Record(context.Background(), actor, models.AuditStatusChange, fmt.Sprintf("writer %d", i), "", fmt.Sprint(n)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	broken, verified, err := writers[0].VerifyChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broken != nil {
+		t.Fatalf("chain broken at entry %d: %s", broken.Record, broken.Reason)
+	}
+	if verified != 2*perWriter {
+		t.Errorf("verified %d entries, want %d", verified, 2*perWriter)
+	}
+}
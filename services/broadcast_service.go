@@ -1,9 +1,11 @@
 package services
 
 import (
+	"context"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"log"
+	"log/slog"
 	"lunobot/database"
+	"lunobot/metrics"
 )
 
 type BroadcastService struct {
@@ -15,7 +17,7 @@ func NewBroadcastService(db *database.DB, bot *tgbotapi.BotAPI) *BroadcastServic
 	return &BroadcastService{db: db, bot: bot}
 }
 
-func (s *BroadcastService) SendBroadcast(message string) (int, error) {
+func (s *BroadcastService) SendBroadcast(ctx context.Context, message string) (int, error) {
 	users, err := s.db.GetAllAdmins()
 	if err != nil {
 		return 0, err
@@ -25,16 +27,18 @@ func (s *BroadcastService) SendBroadcast(message string) (int, error) {
 	for _, user := range users {
 		msg := tgbotapi.NewMessage(user.TelegramID, "🚨 ALERT: "+message)
 		if _, err := s.bot.Send(msg); err != nil {
-			log.Printf("Failed to send broadcast to user %d: %v", user.TelegramID, err)
+			slog.WarnContext(ctx, "Failed to send broadcast", "target_id", user.TelegramID, "error", err)
+			metrics.BroadcastDeliveries.Inc("alert", "failed")
 		} else {
 			sentCount++
+			metrics.BroadcastDeliveries.Inc("alert", "sent")
 		}
 	}
 
 	return sentCount, nil
 }
 
-func (s *BroadcastService) SendOpenNotification() (int, error) {
+func (s *BroadcastService) SendOpenNotification(ctx context.Context) (int, error) {
 	users, err := s.db.GetUsersWithNotifications()
 	if err != nil {
 		return 0, err
@@ -46,9 +50,11 @@ func (s *BroadcastService) SendOpenNotification() (int, error) {
 	for _, user := range users {
 		msg := tgbotapi.NewMessage(user.TelegramID, message)
 		if _, err := s.bot.Send(msg); err != nil {
-			log.Printf("Failed to send open notification to user %d: %v", user.TelegramID, err)
+			slog.WarnContext(ctx, "Failed to send open notification", "target_id", user.TelegramID, "error", err)
+			metrics.BroadcastDeliveries.Inc("open_notification", "failed")
 		} else {
 			sentCount++
+			metrics.BroadcastDeliveries.Inc("open_notification", "sent")
 		}
 	}
 
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// genesisHash is the previous-hash value of the first record in a chain.
+var genesisHash = strings.Repeat("0", 64)
+
+// ChainBreak describes the first record whose hash link does not verify.
+type ChainBreak struct {
+	Source    string
+	Record    int
+	Timestamp time.Time
+	Reason    string
+}
+
+func chainHash(prevHash string, fields ...string) string {
+	sum := sha256.Sum256([]byte(prevHash + "|" + strings.Join(fields, "|")))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,31 +1,219 @@
 package services
 
 import (
+	"context"
+	"log/slog"
 	"lunobot/database"
 	"lunobot/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
+const (
+	duplicateWindow          = 7 * 24 * time.Hour
+	duplicateSimilarity      = 0.8
+	repeatOffenderWindow     = time.Hour
+	repeatOffenderRejections = 3
+	// maxIdeaLength keeps an idea with its header within one message.
+	maxIdeaLength = 4000
+)
+
+type IdeaLimits struct {
+	PerHour     int
+	PerDay      int
+	BannedWords []string
+}
+
 type IdeaService struct {
-	db *database.DB
+	db          *database.DB
+	limits      IdeaLimits
+	rejections  map[int64][]time.Time
+	rejectMutex sync.Mutex
 }
 
-func NewIdeaService(db *database.DB) *IdeaService {
-	return &IdeaService{db: db}
+func NewIdeaService(db *database.DB, limits IdeaLimits) *IdeaService {
+	var bannedWords []string
+	for _, word := range limits.BannedWords {
+		if normalized := normalizeIdeaText(word); normalized != "" {
+			bannedWords = append(bannedWords, normalized)
+		}
+	}
+	limits.BannedWords = bannedWords
+
+	return &IdeaService{
+		db:         db,
+		limits:     limits,
+		rejections: make(map[int64][]time.Time),
+	}
 }
 
-func (s *IdeaService) AddIdea(userID int64, username, content string) error {
+func (s *IdeaService) AddIdea(ctx context.Context, userID int64, username, content string, attachments []models.IdeaAttachment) (*models.Idea, error) {
+	if err := s.checkIdea(userID, content); err != nil {
+		switch err {
+		case models.ErrIdeaRateLimited, models.ErrIdeaDuplicate, models.ErrIdeaBannedWords:
+			s.recordRejection(userID)
+			slog.InfoContext(ctx, "Idea rejected", "reason", err)
+		case models.ErrIdeaTooLong:
+			slog.DebugContext(ctx, "Idea rejected", "reason", err, "length", len(content))
+		}
+		return nil, err
+	}
+
 	idea := &models.Idea{
-		UserID:   userID,
-		Username: username,
-		Content:  content,
+		UserID:      userID,
+		Username:    username,
+		Content:     content,
+		Attachments: attachments,
+	}
+	if err := s.db.AddIdea(idea); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Idea saved", "idea_id", idea.ID, "attachments", len(attachments))
+	return idea, nil
+}
+
+func (s *IdeaService) checkIdea(userID int64, content string) error {
+	if len(content) > maxIdeaLength {
+		return models.ErrIdeaTooLong
 	}
-	return s.db.AddIdea(idea)
+
+	now := time.Now()
+	if s.limits.PerHour > 0 {
+		count, err := s.db.CountUserIdeasSince(userID, now.Add(-time.Hour))
+		if err != nil {
+			return err
+		}
+		if count >= s.limits.PerHour {
+			return models.ErrIdeaRateLimited
+		}
+	}
+	if s.limits.PerDay > 0 {
+		count, err := s.db.CountUserIdeasSince(userID, now.Add(-24*time.Hour))
+		if err != nil {
+			return err
+		}
+		if count >= s.limits.PerDay {
+			return models.ErrIdeaRateLimited
+		}
+	}
+
+	normalized := normalizeIdeaText(content)
+	if normalized == "" {
+		return nil
+	}
+
+	padded := " " + normalized + " "
+	for _, word := range s.limits.BannedWords {
+		if strings.Contains(padded, " "+word+" ") {
+			return models.ErrIdeaBannedWords
+		}
+	}
+
+	recent, err := s.db.GetRecentUserIdeas(userID, now.Add(-duplicateWindow))
+	if err != nil {
+		return err
+	}
+	for _, idea := range recent {
+		if ideaSimilarity(normalized, normalizeIdeaText(idea.Content)) >= duplicateSimilarity {
+			return models.ErrIdeaDuplicate
+		}
+	}
+
+	return nil
+}
+
+// recordRejection notes a rejection of the user's idea and forgets those
+// older than the offender window, for every user.
+func (s *IdeaService) recordRejection(userID int64) {
+	s.rejectMutex.Lock()
+	defer s.rejectMutex.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-repeatOffenderWindow)
+	for id, times := range s.rejections {
+		recent := slices.DeleteFunc(times, func(t time.Time) bool { return !t.After(cutoff) })
+		if len(recent) == 0 {
+			delete(s.rejections, id)
+		} else {
+			s.rejections[id] = recent
+		}
+	}
+	s.rejections[userID] = append(s.rejections[userID], now)
+}
+
+// ShouldReportOffender reports true exactly once each time a user reaches
+// the rejection threshold within the offender window.
+func (s *IdeaService) ShouldReportOffender(userID int64) bool {
+	s.rejectMutex.Lock()
+	defer s.rejectMutex.Unlock()
+	return len(s.rejections[userID]) == repeatOffenderRejections
+}
+
+func (s *IdeaService) GetIdeaAttachments(ideaID int64) ([]models.IdeaAttachment, error) {
+	return s.db.GetIdeaAttachments(ideaID)
 }
 
 func (s *IdeaService) GetAllIdeas() ([]models.Idea, error) {
 	return s.db.GetAllIdeas()
 }
 
+func (s *IdeaService) GetActiveIdeas() ([]models.Idea, error) {
+	return s.db.GetActiveIdeas()
+}
+
+func (s *IdeaService) GetIdeasSince(since time.Time) ([]models.Idea, error) {
+	return s.db.GetIdeasSince(since)
+}
+
+func (s *IdeaService) GetIdeaByID(ideaID int64) (*models.Idea, error) {
+	return s.db.GetIdeaByID(ideaID)
+}
+
+func (s *IdeaService) UpdateIdeaStatus(ideaID int64, status models.IdeaStatus) error {
+	return s.db.UpdateIdeaStatus(ideaID, status)
+}
+
 func (s *IdeaService) DeleteIdea(ideaID int64) error {
 	return s.db.DeleteIdea(ideaID)
 }
+
+func normalizeIdeaText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// ideaSimilarity returns the Jaccard similarity of the word sets of two
+// normalized texts.
+func ideaSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	wordsA := strings.Fields(a)
+	wordsB := strings.Fields(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	setA := make(map[string]bool, len(wordsA))
+	for _, w := range wordsA {
+		setA[w] = true
+	}
+	setB := make(map[string]bool, len(wordsB))
+	for _, w := range wordsB {
+		setB[w] = true
+	}
+
+	common := 0
+	for w := range setA {
+		if setB[w] {
+			common++
+		}
+	}
+	union := len(setA) + len(setB) - common
+	return float64(common) / float64(union)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"lunobot/database"
+	"lunobot/models"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIdeaUser = 42
+
+func newTestIdeaService(t *testing.T, limits IdeaLimits, previous ...string) *IdeaService {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.CreateUser(&models.User{TelegramID: testIdeaUser, Username: "tester", Rights: models.RightsDefault}); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range previous {
+		if err := db.AddIdea(&models.Idea{UserID: testIdeaUser, Username: "tester", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewIdeaService(db, limits)
+}
+
+func TestCheckIdea(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   IdeaLimits
+		previous []string
+		content  string
+		want     error
+	}{
+		{"accepted", IdeaLimits{}, nil, "Buy a new kettle", nil},
+		{"longest accepted", IdeaLimits{}, nil, strings.Repeat("a", maxIdeaLength), nil},
+		{"too long", IdeaLimits{}, nil, strings.Repeat("a", maxIdeaLength+1), models.ErrIdeaTooLong},
+		{"hourly limit", IdeaLimits{PerHour: 1}, []string{"Paint the door"}, "Buy a new kettle", models.ErrIdeaRateLimited},
+		{"daily limit", IdeaLimits{PerDay: 2}, []string{"Paint the door", "Fix the lamp"}, "Buy a new kettle", models.ErrIdeaRateLimited},
+		{"under the limits", IdeaLimits{PerHour: 2, PerDay: 2}, []string{"Paint the door"}, "Buy a new kettle", nil},
+		{"duplicate", IdeaLimits{}, []string{"Buy a new kettle for the kitchen"}, "buy a NEW kettle, for the kitchen!", models.ErrIdeaDuplicate},
+		{"different idea", IdeaLimits{}, []string{"Buy a new kettle for the kitchen"}, "Paint the kitchen door", nil},
+		{"no words", IdeaLimits{}, []string{"!!!"}, "???", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestIdeaService(t, tt.limits, tt.previous...)
+			if err := s.checkIdea(testIdeaUser, tt.content); err != tt.want {
+				t.Errorf("checkIdea = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIdeaBannedWords(t *testing.T) {
+	s := newTestIdeaService(t, IdeaLimits{BannedWords: []string{"Spam", "free money", "  "}})
+	tests := []struct {
+		content string
+		want    error
+	}{
+		{"spam", models.ErrIdeaBannedWords},
+		{"Some SPAM here", models.ErrIdeaBannedWords},
+		{"spam, again", models.ErrIdeaBannedWords},
+		{"get FREE   money now", models.ErrIdeaBannedWords},
+		{"spammy ideas", nil},
+		{"antispam filter", nil},
+		{"free time and money", nil},
+	}
+	for _, tt := range tests {
+		if err := s.checkIdea(testIdeaUser, tt.content); err != tt.want {
+			t.Errorf("checkIdea(%q) = %v, want %v", tt.content, err, tt.want)
+		}
+	}
+}
+
+func TestIdeaSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"buy a kettle", "buy a kettle", 1},
+		{"buy a kettle", "kettle a buy", 1},
+		{"buy a kettle", "buy a lamp", 0.5},
+		{"buy a kettle", "paint the door", 0},
+		{"buy buy kettle", "buy kettle", 1},
+		{"", "buy a kettle", 0},
+		{"buy a kettle", "", 0},
+		{"", "", 1},
+	}
+	for _, tt := range tests {
+		if got := ideaSimilarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("ideaSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestAddIdeaReportsOnlyPolicyRejections(t *testing.T) {
+	s := newTestIdeaService(t, IdeaLimits{BannedWords: []string{"spam"}})
+	for range repeatOffenderRejections {
+		if _, err := s.AddIdea(context.Background(), testIdeaUser, "tester", strings.Repeat("a", maxIdeaLength+1), nil); err != models.ErrIdeaTooLong {
+			t.Fatalf("AddIdea = %v, want %v", err, models.ErrIdeaTooLong)
+		}
+	}
+	if s.ShouldReportOffender(testIdeaUser) {
+		t.Error("overlong ideas were reported as offences")
+	}
+
+	for range repeatOffenderRejections {
+		if _, err := s.AddIdea(context.Background(), testIdeaUser, "tester", "spam", nil); err != models.ErrIdeaBannedWords {
+			t.Fatalf("AddIdea = %v, want %v", err, models.ErrIdeaBannedWords)
+		}
+	}
+	if !s.ShouldReportOffender(testIdeaUser) {
+		t.Error("repeated banned words were not reported")
+	}
+}
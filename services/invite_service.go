@@ -0,0 +1,71 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"lunobot/database"
+	"lunobot/models"
+	"time"
+)
+
+// InvitePrefix starts the /start payload of invite deep links.
+const InvitePrefix = "inv_"
+
+type InviteService struct {
+	db *database.DB
+}
+
+func NewInviteService(db *database.DB) *InviteService {
+	return &InviteService{db: db}
+}
+
+func (s *InviteService) CreateInvite(roleID int64, maxUses int, expiresAt time.Time, createdBy int64) (*models.Invite, error) {
+	code := make([]byte, 8)
+	if _, err := rand.Read(code); err != nil {
+		return nil, err
+	}
+
+	invite := &models.Invite{
+		Code:      hex.EncodeToString(code),
+		RoleID:    roleID,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedBy: createdBy,
+	}
+	if err := s.db.CreateInvite(invite); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// GetUsableInvite returns the invite with the code if it can still be redeemed.
+func (s *InviteService) GetUsableInvite(code string) (*models.Invite, error) {
+	invite, err := s.db.GetInviteByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if !invite.ExpiresAt.After(time.Now()) {
+		return nil, models.ErrInviteExpired
+	}
+	if invite.Uses >= invite.MaxUses {
+		return nil, models.ErrInviteUsedUp
+	}
+	return invite, nil
+}
+
+// UseInvite counts one redemption. It fails if another user took the last use.
+func (s *InviteService) UseInvite(invite *models.Invite) error {
+	return s.db.UseInvite(invite.ID, time.Now())
+}
+
+func (s *InviteService) RefundInvite(invite *models.Invite) error {
+	return s.db.RefundInvite(invite.ID)
+}
+
+func (s *InviteService) GetActiveInvites() ([]models.Invite, error) {
+	return s.db.GetActiveInvites(time.Now())
+}
+
+func (s *InviteService) DeleteInvite(inviteID int64) error {
+	return s.db.DeleteInvite(inviteID)
+}
@@ -2,32 +2,41 @@ package services
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
-const (
-	logDir         = "log"
-	entriesPerPage = 5
-)
-
 type LogEntry struct {
 	Timestamp  time.Time
 	Action     string
 	ActionData string
 	ChangedBy  string
+	PrevHash   string
+	Hash       string
 }
 
+// LogService keeps the status log in monthly files. Timestamps are written
+// as wall-clock time in location.
 type LogService struct {
-	logDir string
+	logDir         string
+	entriesPerPage int
+	location       *time.Location
+	chainHead      string
+	mu             sync.Mutex
 }
 
-func NewLogService() *LogService {
+func NewLogService(logDir string, entriesPerPage int, location *time.Location) *LogService {
 	ls := &LogService{
-		logDir: logDir,
+		logDir:         logDir,
+		entriesPerPage: entriesPerPage,
+		location:       location,
 	}
 	ls.ensureLogDir()
 	return ls
@@ -48,29 +57,40 @@ func (ls *LogService) GetLogFilePath(month, year int) string {
 }
 
 func (ls *LogService) GetCurrentMonthLogPath() string {
-	now := time.Now()
+	now := time.Now().In(ls.location)
 	return ls.GetLogFilePath(int(now.Month()), now.Year())
 }
 
-func (ls *LogService) LogStatusChange(isOpen bool, changedBy string) error {
+func (ls *LogService) LogStatusChange(ctx context.Context, isOpen bool, changedBy string) error {
 	status := "closed"
 	if isOpen {
 		status = "open"
 	}
-	return ls.writeLogEntry("status", status, changedBy)
+	return ls.writeLogEntry(ctx, "status", status, changedBy)
 }
 
-func (ls *LogService) LogKeysChange(keysAtAdmin bool, changedBy string) error {
+func (ls *LogService) LogKeysChange(ctx context.Context, keysAtAdmin bool, changedBy string) error {
 	location := "lobby"
 	if keysAtAdmin {
 		location = "admin"
 	}
-	return ls.writeLogEntry("keys", location, changedBy)
+	return ls.writeLogEntry(ctx, "keys", location, changedBy)
 }
 
-func (ls *LogService) writeLogEntry(action, actionData, changedBy string) error {
+func (ls *LogService) writeLogEntry(ctx context.Context, action, actionData, changedBy string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
 	ls.ensureLogDir()
 
+	if ls.chainHead == "" {
+		head, err := ls.loadChainHead()
+		if err != nil {
+			return err
+		}
+		ls.chainHead = head
+	}
+
 	logPath := ls.GetCurrentMonthLogPath()
 	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -78,16 +98,167 @@ func (ls *LogService) writeLogEntry(action, actionData, changedBy string) error
 	}
 	defer file.Close()
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	entry := fmt.Sprintf("%s|%s|%s|%s\n", timestamp, action, actionData, changedBy)
+	timestamp := time.Now().In(ls.location).Format("2006-01-02 15:04:05")
+	changedBy = strings.ReplaceAll(changedBy, "|", "/")
+	hash := chainHash(ls.chainHead, timestamp, action, actionData, changedBy)
+	entry := fmt.Sprintf("%s|%s|%s|%s|%s|%s\n", timestamp, action, actionData, changedBy, ls.chainHead, hash)
 
 	if _, err := file.WriteString(entry); err != nil {
 		return fmt.Errorf("failed to write log entry: %w", err)
 	}
+	slog.DebugContext(ctx, "Wrote status log entry", "action", action, "value", actionData, "file", logPath)
 
+	ls.chainHead = hash
 	return nil
 }
 
+func entryHash(entry LogEntry) string {
+	return chainHash(entry.PrevHash, entry.Timestamp.Format("2006-01-02 15:04:05"),
+		entry.Action, entry.ActionData, entry.ChangedBy)
+}
+
+type logFile struct {
+	name  string
+	month int
+	year  int
+}
+
+// listLogFiles returns the monthly log files in chronological order.
+func (ls *LogService) listLogFiles() ([]logFile, error) {
+	paths, err := filepath.Glob(filepath.Join(ls.logDir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []logFile
+	for _, path := range paths {
+		var f logFile
+		f.name = filepath.Base(path)
+		if _, err := fmt.Sscanf(f.name, "%02d.%d.log", &f.month, &f.year); err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].year != files[j].year {
+			return files[i].year < files[j].year
+		}
+		return files[i].month < files[j].month
+	})
+	return files, nil
+}
+
+func (ls *LogService) readLogLines(month, year int) ([]string, error) {
+	file, err := os.Open(ls.GetLogFilePath(month, year))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading log file: %w", err)
+	}
+	return lines, nil
+}
+
+func (ls *LogService) loadChainHead() (string, error) {
+	files, err := ls.listLogFiles()
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		head, err := ls.GetChainHead(files[i].month, files[i].year)
+		if err != nil {
+			return "", err
+		}
+		if head != "" {
+			return head, nil
+		}
+	}
+	return genesisHash, nil
+}
+
+// GetChainHead returns the hash of the last chained entry in the given
+// month's log, or an empty string if it has none.
+func (ls *LogService) GetChainHead(month, year int) (string, error) {
+	if !ls.LogFileExists(month, year) {
+		return "", nil
+	}
+
+	lines, err := ls.readLogLines(month, year)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		entry, err := parseLogEntry(lines[i], ls.location)
+		if err == nil && entry.Hash != "" {
+			return entry.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// VerifyChain walks all monthly logs in order and returns the first entry
+// whose hash link is broken, along with the number of chained entries that
+// verified. Entries written before chaining was introduced are skipped as
+// long as they precede the first chained entry.
+func (ls *LogService) VerifyChain() (*ChainBreak, int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	files, err := ls.listLogFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	prevHash := genesisHash
+	started := false
+	verified := 0
+	for _, f := range files {
+		lines, err := ls.readLogLines(f.month, f.year)
+		if err != nil {
+			return nil, verified, err
+		}
+
+		for i, line := range lines {
+			entry, err := parseLogEntry(line, ls.location)
+			brokenAt := func(reason string) *ChainBreak {
+				return &ChainBreak{Source: f.name, Record: i + 1, Timestamp: entry.Timestamp, Reason: reason}
+			}
+			switch {
+			case err != nil:
+				if started {
+					return brokenAt("malformed entry"), verified, nil
+				}
+				continue
+			case entry.Hash == "":
+				if started {
+					return brokenAt("entry is not chained"), verified, nil
+				}
+				continue
+			case entry.PrevHash != prevHash:
+				return brokenAt("previous hash mismatch"), verified, nil
+			case entryHash(entry) != entry.Hash:
+				return brokenAt("hash mismatch"), verified, nil
+			}
+
+			prevHash = entry.Hash
+			started = true
+			verified++
+		}
+	}
+
+	return nil, verified, nil
+}
+
 func (ls *LogService) GetLogEntries(month, year int, page int) ([]LogEntry, int, error) {
 	logPath := ls.GetLogFilePath(month, year)
 
@@ -106,7 +277,7 @@ func (ls *LogService) GetLogEntries(month, year int, page int) ([]LogEntry, int,
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		entry, err := parseLogEntry(line)
+		entry, err := parseLogEntry(line, ls.location)
 		if err != nil {
 			continue
 		}
@@ -126,7 +297,7 @@ func (ls *LogService) GetLogEntries(month, year int, page int) ([]LogEntry, int,
 		allEntries[i], allEntries[j] = allEntries[j], allEntries[i]
 	}
 
-	totalPages := (totalEntries + entriesPerPage - 1) / entriesPerPage
+	totalPages := (totalEntries + ls.entriesPerPage - 1) / ls.entriesPerPage
 
 	if page < 1 {
 		page = 1
@@ -135,8 +306,8 @@ func (ls *LogService) GetLogEntries(month, year int, page int) ([]LogEntry, int,
 		page = totalPages
 	}
 
-	startIdx := (page - 1) * entriesPerPage
-	endIdx := startIdx + entriesPerPage
+	startIdx := (page - 1) * ls.entriesPerPage
+	endIdx := startIdx + ls.entriesPerPage
 	if endIdx > totalEntries {
 		endIdx = totalEntries
 	}
@@ -167,7 +338,7 @@ func (ls *LogService) GetTotalPages(month, year int) (int, error) {
 		return 0, fmt.Errorf("error reading log file: %w", err)
 	}
 
-	return (lineCount + entriesPerPage - 1) / entriesPerPage, nil
+	return (lineCount + ls.entriesPerPage - 1) / ls.entriesPerPage, nil
 }
 
 func (ls *LogService) LogFileExists(month, year int) bool {
@@ -176,11 +347,11 @@ func (ls *LogService) LogFileExists(month, year int) bool {
 	return err == nil
 }
 
-func parseLogEntry(line string) (LogEntry, error) {
+func parseLogEntry(line string, location *time.Location) (LogEntry, error) {
 	parts := strings.Split(line, "|")
 
 	if len(parts) == 3 {
-		timestamp, err := time.Parse("2006-01-02 15:04:05", parts[0])
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", parts[0], location)
 		if err != nil {
 			return LogEntry{}, fmt.Errorf("invalid timestamp format: %w", err)
 		}
@@ -193,7 +364,20 @@ func parseLogEntry(line string) (LogEntry, error) {
 	}
 
 	if len(parts) == 4 {
-		timestamp, err := time.Parse("2006-01-02 15:04:05", parts[0])
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", parts[0], location)
+		if err != nil {
+			return LogEntry{}, fmt.Errorf("invalid timestamp format: %w", err)
+		}
+		return LogEntry{
+			Timestamp:  timestamp,
+			Action:     parts[1],
+			ActionData: parts[2],
+			ChangedBy:  parts[3],
+		}, nil
+	}
+
+	if len(parts) == 6 {
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", parts[0], location)
 		if err != nil {
 			return LogEntry{}, fmt.Errorf("invalid timestamp format: %w", err)
 		}
@@ -202,6 +386,8 @@ func parseLogEntry(line string) (LogEntry, error) {
 			Action:     parts[1],
 			ActionData: parts[2],
 			ChangedBy:  parts[3],
+			PrevHash:   parts[4],
+			Hash:       parts[5],
 		}, nil
 	}
 
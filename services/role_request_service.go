@@ -0,0 +1,71 @@
+package services
+
+import (
+	"lunobot/database"
+	"lunobot/models"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxRoleRequestReasonLength = 500
+
+type RoleRequestService struct {
+	db *database.DB
+}
+
+func NewRoleRequestService(db *database.DB) *RoleRequestService {
+	return &RoleRequestService{db: db}
+}
+
+// CreateRequest records the user's request for the role. A user can have
+// only one pending request at a time.
+func (s *RoleRequestService) CreateRequest(telegramID, roleID int64, reason string) (*models.RoleRequest, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxRoleRequestReasonLength {
+		return nil, models.ErrInvalidRoleRequestReason
+	}
+
+	pending, err := s.db.HasPendingRoleRequest(telegramID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, models.ErrRoleRequestPending
+	}
+
+	request := &models.RoleRequest{
+		TelegramID: telegramID,
+		RoleID:     roleID,
+		Reason:     reason,
+	}
+	if err := s.db.CreateRoleRequest(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *RoleRequestService) HasPendingRequest(telegramID int64) (bool, error) {
+	return s.db.HasPendingRoleRequest(telegramID)
+}
+
+func (s *RoleRequestService) GetRequest(requestID int64) (*models.RoleRequest, error) {
+	return s.db.GetRoleRequest(requestID)
+}
+
+// Decide approves or denies a pending request and returns it.
+func (s *RoleRequestService) Decide(requestID int64, approve bool, decidedBy int64) (*models.RoleRequest, error) {
+	status := models.RoleRequestDenied
+	if approve {
+		status = models.RoleRequestApproved
+	}
+	if err := s.db.DecideRoleRequest(requestID, status, decidedBy); err != nil {
+		return nil, err
+	}
+	return s.db.GetRoleRequest(requestID)
+}
+
+// Reopen puts an approved request whose role could not be granted back to
+// pending, so it can be decided again.
+func (s *RoleRequestService) Reopen(requestID int64) error {
+	return s.db.ReopenRoleRequest(requestID)
+}
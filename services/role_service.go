@@ -0,0 +1,100 @@
+package services
+
+import (
+	"lunobot/database"
+	"lunobot/models"
+	"strings"
+)
+
+const maxRoleNameLength = 32
+
+type RoleService struct {
+	db *database.DB
+}
+
+func NewRoleService(db *database.DB) *RoleService {
+	return &RoleService{db: db}
+}
+
+func (s *RoleService) GetRoles() ([]models.Role, error) {
+	return s.db.GetRoles()
+}
+
+func (s *RoleService) GetRoleByID(roleID int64) (*models.Role, error) {
+	return s.db.GetRoleByID(roleID)
+}
+
+func (s *RoleService) GetRoleByName(name string) (*models.Role, error) {
+	return s.db.GetRoleByName(name)
+}
+
+func (s *RoleService) CreateRole(name string) (*models.Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxRoleNameLength || strings.Contains(name, "\n") {
+		return nil, models.ErrInvalidRoleName
+	}
+	return s.db.CreateRole(name)
+}
+
+// TogglePermission grants or revokes a permission on a custom role and keeps
+// the legacy rights level of the role's users in sync.
+func (s *RoleService) TogglePermission(roleID int64, permission models.Permission) (*models.Role, error) {
+	if !permission.IsValid() {
+		return nil, models.ErrInvalidPermission
+	}
+
+	role, err := s.db.GetRoleByID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if role.BuiltIn {
+		return nil, models.ErrRoleBuiltIn
+	}
+
+	if permission == models.PermRightsManage && role.Has(permission) {
+		holders, err := s.db.CountUsersWithRole(roleID)
+		if err != nil {
+			return nil, err
+		}
+		others, err := s.db.CountUsersWithPermission(permission, roleID)
+		if err != nil {
+			return nil, err
+		}
+		if holders > 0 && others == 0 {
+			return nil, models.ErrLastAdmin
+		}
+	}
+
+	if err := s.db.SetRolePermission(roleID, permission, !role.Has(permission)); err != nil {
+		return nil, err
+	}
+
+	role, err = s.db.GetRoleByID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.SyncRoleRights(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s *RoleService) DeleteRole(roleID int64) error {
+	role, err := s.db.GetRoleByID(roleID)
+	if err != nil {
+		return err
+	}
+	if role.BuiltIn {
+		return models.ErrRoleBuiltIn
+	}
+
+	count, err := s.db.CountUsersWithRole(roleID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return models.ErrRoleInUse
+	}
+
+	return s.db.DeleteRole(roleID)
+}
@@ -1,70 +1,175 @@
 package services
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"lunobot/database"
+	"lunobot/logging"
+	"lunobot/metrics"
 	"lunobot/models"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type SchedulerService struct {
-	db               *database.DB
-	statusService    *StatusService
-	broadcastService *BroadcastService
-	stopChan         chan struct{}
+	db                *database.DB
+	statusService     *StatusService
+	broadcastService  *BroadcastService
+	tick              time.Duration
+	location          *time.Location
+	stopChan          chan struct{}
+	stopped           chan struct{}
+	digestTime        string
+	digestHandler     func(ctx context.Context) error
+	lastDigestDate    string
+	roleExpiry        func(ctx context.Context) error
+	restrictionExpiry func(ctx context.Context) error
+	autoClose         func(ctx context.Context, settings *models.AutoCloseSettings, closedBy string)
+	lastTick          atomic.Int64
 }
 
-func NewSchedulerService(db *database.DB, statusService *StatusService, broadcastService *BroadcastService) *SchedulerService {
+// NewSchedulerService creates a scheduler that runs its jobs every tick. The
+// tick must be shorter than a minute so that no scheduled minute is missed.
+func NewSchedulerService(db *database.DB, statusService *StatusService, broadcastService *BroadcastService, tick time.Duration, location *time.Location) *SchedulerService {
 	return &SchedulerService{
 		db:               db,
 		statusService:    statusService,
 		broadcastService: broadcastService,
+		tick:             tick,
+		location:         location,
 		stopChan:         make(chan struct{}),
+		stopped:          make(chan struct{}),
 	}
 }
 
+// SetIdeaDigest registers the handler that sends the daily idea digest at
+// digestTime (HH:MM). It must be called before Start.
+func (s *SchedulerService) SetIdeaDigest(digestTime string, handler func(ctx context.Context) error) {
+	s.digestTime = digestTime
+	s.digestHandler = handler
+}
+
+// SetRoleExpiry registers the handler that takes back expired temporary
+// roles. It runs on every tick and must be registered before Start.
+func (s *SchedulerService) SetRoleExpiry(handler func(ctx context.Context) error) {
+	s.roleExpiry = handler
+}
+
+// SetRestrictionExpiry registers the handler that lifts expired bans and
+// mutes. It runs on every tick and must be registered before Start.
+func (s *SchedulerService) SetRestrictionExpiry(handler func(ctx context.Context) error) {
+	s.restrictionExpiry = handler
+}
+
+// SetAutoCloseHandler registers the handler told about every auto-close
+// that closed Lunoteka. It must be registered before Start.
+func (s *SchedulerService) SetAutoCloseHandler(handler func(ctx context.Context, settings *models.AutoCloseSettings, closedBy string)) {
+	s.autoClose = handler
+}
+
 func (s *SchedulerService) Start() {
+	s.lastTick.Store(time.Now().UnixNano())
 	go s.run()
 }
 
-func (s *SchedulerService) Stop() {
+// Stop stops the scheduler and waits for the jobs running at the moment to
+// finish, or for ctx to be done.
+func (s *SchedulerService) Stop(ctx context.Context) error {
 	close(s.stopChan)
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *SchedulerService) run() {
-	ticker := time.NewTicker(30 * time.Second)
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.tick)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.checkAndExecute()
+			s.lastTick.Store(time.Now().UnixNano())
+			s.runJob("auto_close", s.checkAndExecute)
+			s.runJob("idea_digest", s.checkIdeaDigest)
+			if s.roleExpiry != nil {
+				s.runJob("role_expiry", s.roleExpiry)
+			}
+			if s.restrictionExpiry != nil {
+				s.runJob("restriction_expiry", s.restrictionExpiry)
+			}
 		case <-s.stopChan:
-			log.Println("Scheduler stopped")
+			slog.Info("Scheduler stopped")
 			return
 		}
 	}
 }
 
-func (s *SchedulerService) checkAndExecute() {
+// CheckLiveness reports when the scheduler last ticked and fails if it has
+// missed several ticks in a row.
+func (s *SchedulerService) CheckLiveness() (map[string]interface{}, error) {
+	lastTick := s.lastTick.Load()
+	if lastTick == 0 {
+		return nil, fmt.Errorf("scheduler is not running")
+	}
+	last := time.Unix(0, lastTick)
+	details := map[string]interface{}{"last_tick": last}
+	if time.Since(last) > 3*s.tick {
+		return details, fmt.Errorf("scheduler has not ticked since %s", last.Format(time.RFC3339))
+	}
+	return details, nil
+}
+
+// runJob runs one of the scheduler's jobs under its own correlation ID and
+// records whether it failed.
+func (s *SchedulerService) runJob(name string, job func(ctx context.Context) error) {
+	ctx := logging.With(logging.NewCorrelation(context.Background()), slog.String("job", name))
+	metrics.SchedulerRuns.Inc(name)
+	if err := job(ctx); err != nil {
+		metrics.SchedulerFailures.Inc(name)
+		slog.ErrorContext(ctx, "Scheduler job failed", "error", err)
+	}
+}
+
+func (s *SchedulerService) checkAndExecute(ctx context.Context) error {
 	settings, err := s.db.GetAutoCloseSettings()
 	if err != nil {
-		log.Printf("Error getting auto-close settings: %v", err)
-		return
+		return fmt.Errorf("failed to get auto-close settings: %w", err)
 	}
 
 	if !settings.Enabled {
-		return
+		return nil
 	}
 
-	now := time.Now()
+	now := time.Now().In(s.location)
 	currentTime := now.Format("15:04")
 
 	if s.timeMatches(currentTime, settings.CloseTime) {
-		log.Printf("Auto-close triggered at %s", currentTime)
-		s.executeAutoClose(settings)
+		slog.InfoContext(ctx, "Auto-close triggered", "time", currentTime)
+		return s.executeAutoClose(ctx, settings)
+	}
+	return nil
+}
+
+func (s *SchedulerService) checkIdeaDigest(ctx context.Context) error {
+	if s.digestHandler == nil {
+		return nil
+	}
+
+	now := time.Now().In(s.location)
+	today := now.Format("2006-01-02")
+	if s.lastDigestDate == today || !s.timeMatches(now.Format("15:04"), s.digestTime) {
+		return nil
 	}
+
+	s.lastDigestDate = today
+	slog.InfoContext(ctx, "Sending daily idea digest")
+	return s.digestHandler(ctx)
 }
 
 func (s *SchedulerService) timeMatches(current, target string) bool {
@@ -78,15 +183,14 @@ func (s *SchedulerService) timeMatches(current, target string) bool {
 	return currentParts[0] == targetParts[0] && currentParts[1] == targetParts[1]
 }
 
-func (s *SchedulerService) executeAutoClose(settings *models.AutoCloseSettings) {
+func (s *SchedulerService) executeAutoClose(ctx context.Context, settings *models.AutoCloseSettings) error {
 	status, err := s.statusService.GetStatus()
 	if err != nil {
-		log.Printf("Error getting status for auto-close: %v", err)
-		return
+		return fmt.Errorf("failed to get status for auto-close: %w", err)
 	}
 
 	if !status.IsOpen {
-		return
+		return nil
 	}
 
 	updatedBy := settings.LastStatusBy
@@ -96,19 +200,26 @@ func (s *SchedulerService) executeAutoClose(settings *models.AutoCloseSettings)
 
 	err = s.db.UpdateOpenStatusAuto(false, settings.KeysToLobby, updatedBy)
 	if err != nil {
-		log.Printf("Error executing auto-close: %v", err)
-		return
+		return fmt.Errorf("failed to execute auto-close: %w", err)
 	}
 
-	log.Printf("Auto-close executed. Status set to closed by %s, keys to lobby: %v", updatedBy, settings.KeysToLobby)
+	slog.InfoContext(ctx, "Auto-close executed", "closed_by", updatedBy, "keys_to_lobby", settings.KeysToLobby)
+	if s.autoClose != nil {
+		s.autoClose(ctx, settings, updatedBy)
+	}
+	return nil
 }
 
 func (s *SchedulerService) GetSettings() (*models.AutoCloseSettings, error) {
 	return s.db.GetAutoCloseSettings()
 }
 
-func (s *SchedulerService) UpdateSettings(enabled bool, closeTime string, keysToLobby bool) error {
-	return s.db.UpdateAutoCloseSettings(enabled, closeTime, keysToLobby)
+func (s *SchedulerService) UpdateSettings(ctx context.Context, enabled bool, closeTime string, keysToLobby bool) error {
+	if err := s.db.UpdateAutoCloseSettings(enabled, closeTime, keysToLobby); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Auto-close settings updated", "enabled", enabled, "time", closeTime, "keys_to_lobby", keysToLobby)
+	return nil
 }
 
 func (s *SchedulerService) UpdateLastUser(username string) error {
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"log/slog"
 	"lunobot/database"
 	"lunobot/models"
 )
@@ -17,10 +19,18 @@ func (s *StatusService) GetStatus() (*models.Status, error) {
 	return s.db.GetStatus()
 }
 
-func (s *StatusService) UpdateOpenStatus(isOpen bool, user *models.User) error {
-	return s.db.UpdateOpenStatus(isOpen, user)
+func (s *StatusService) UpdateOpenStatus(ctx context.Context, isOpen bool, user *models.User) error {
+	if err := s.db.UpdateOpenStatus(isOpen, user); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Open status changed", "is_open", isOpen, "changed_by", user.GetDisplayName())
+	return nil
 }
 
-func (s *StatusService) UpdateTechnicalStatus(technicalStatus bool, user *models.User) error {
-	return s.db.UpdateTechnicalStatus(technicalStatus, user)
+func (s *StatusService) UpdateTechnicalStatus(ctx context.Context, technicalStatus bool, user *models.User) error {
+	if err := s.db.UpdateTechnicalStatus(technicalStatus, user); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Keys moved", "at_admin", technicalStatus, "changed_by", user.GetDisplayName())
+	return nil
 }
@@ -1,19 +1,34 @@
 package services
 
 import (
+	"context"
+	"log/slog"
 	"lunobot/database"
 	"lunobot/models"
+	"sync"
+	"time"
 )
 
 type UserService struct {
-	db *database.DB
+	db           *database.DB
+	usersPerPage int
+	rightsMu     sync.Mutex
+	onPending    func(ctx context.Context, user *models.User)
 }
 
-func NewUserService(db *database.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *database.DB, usersPerPage int) *UserService {
+	return &UserService{db: db, usersPerPage: usersPerPage}
 }
 
-func (s *UserService) GetOrCreateUser(telegramID int64, username, firstName, lastName string) (*models.User, error) {
+// SetClosedMembership turns on closed-membership mode: users who write to the
+// bot for the first time are pending until an admin approves them, and
+// onPending is called for each of them. It must be called before the bot
+// starts handling updates.
+func (s *UserService) SetClosedMembership(onPending func(ctx context.Context, user *models.User)) {
+	s.onPending = onPending
+}
+
+func (s *UserService) GetOrCreateUser(ctx context.Context, telegramID int64, username, firstName, lastName string) (*models.User, error) {
 	user, err := s.db.GetUserByTelegramID(telegramID)
 	if err == models.ErrUserNotFound {
 		user = &models.User{
@@ -23,15 +38,35 @@ func (s *UserService) GetOrCreateUser(telegramID int64, username, firstName, las
 			LastName:             lastName,
 			Rights:               models.RightsDefault,
 			NotificationsEnabled: false,
+			Membership:           models.MembershipActive,
+		}
+		if s.onPending != nil {
+			user.Membership = models.MembershipPending
 		}
 		if err := s.db.CreateUser(user); err != nil {
 			return nil, err
 		}
+		user.LastSeenAt = time.Now()
+		if err := s.loadRole(user); err != nil {
+			return nil, err
+		}
+		if user.Membership == models.MembershipPending {
+			s.onPending(ctx, user)
+		}
 		return user, nil
 	} else if err != nil {
 		return nil, err
 	}
 
+	if err := s.loadRole(user); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.UpdateLastSeen(telegramID); err != nil {
+		return nil, err
+	}
+	user.LastSeenAt = time.Now()
+
 	if user.Username != username || user.FirstName != firstName || user.LastName != lastName {
 		user.Username = username
 		user.FirstName = firstName
@@ -44,12 +79,278 @@ func (s *UserService) GetOrCreateUser(telegramID int64, username, firstName, las
 	return user, nil
 }
 
-func (s *UserService) UpdateUserRights(telegramID int64, rights models.Rights) error {
-	return s.db.UpdateUserRights(telegramID, rights)
+// loadRole attaches the user's role. Users without a stored role keep a nil
+// Role and fall back to the built-in role for their rights level.
+func (s *UserService) loadRole(user *models.User) error {
+	if user.RoleID == 0 {
+		return nil
+	}
+	role, err := s.db.GetRoleByID(user.RoleID)
+	if err == models.ErrRoleNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+// IsAdminDemotion reports whether giving the role to the user takes away
+// their ability to manage rights.
+func IsAdminDemotion(user *models.User, role *models.Role) bool {
+	return user.HasPermission(models.PermRightsManage) && !role.Has(models.PermRightsManage)
 }
 
-func (s *UserService) UpdateUserRightsByUsername(username string, rights models.Rights) error {
-	return s.db.UpdateUserRightsByUsername(username, rights)
+// LosesPermissions reports whether giving the user the role would take away
+// any permission they have now.
+func LosesPermissions(user *models.User, role *models.Role) bool {
+	for _, permission := range models.AllPermissions {
+		if user.HasPermission(permission) && !role.Has(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateUserRights gives the role to the user permanently. It refuses to
+// demote the last user who can manage rights.
+func (s *UserService) UpdateUserRights(ctx context.Context, telegramID int64, role *models.Role) error {
+	s.rightsMu.Lock()
+	defer s.rightsMu.Unlock()
+
+	if _, err := s.checkRoleChange(telegramID, role); err != nil {
+		return err
+	}
+	if err := s.db.UpdateUserRights(telegramID, role); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Role changed", "target_id", telegramID, "role", role.Name)
+	return nil
+}
+
+// GrantTemporaryRole gives the role to the user until expiresAt, when
+// RevokeExpiredRoles restores the role they had before.
+func (s *UserService) GrantTemporaryRole(ctx context.Context, telegramID int64, role *models.Role, grantedBy int64, expiresAt time.Time) error {
+	s.rightsMu.Lock()
+	defer s.rightsMu.Unlock()
+
+	user, err := s.checkRoleChange(telegramID, role)
+	if err != nil {
+		return err
+	}
+
+	previousRoleID := user.RoleID
+	if !user.RoleExpiresAt.IsZero() && user.PreviousRoleID != 0 {
+		previousRoleID = user.PreviousRoleID
+	}
+	if err := s.db.UpdateUserRightsUntil(telegramID, role, previousRoleID, grantedBy, expiresAt); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Temporary role granted", "target_id", telegramID, "role", role.Name, "expires_at", expiresAt)
+	return nil
+}
+
+func (s *UserService) checkRoleChange(telegramID int64, role *models.Role) (*models.User, error) {
+	user, err := s.GetUserByTelegramID(telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsAdminDemotion(user, role) {
+		admins, err := s.db.CountUsersWithPermission(models.PermRightsManage, 0)
+		if err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, models.ErrLastAdmin
+		}
+	}
+	return user, nil
+}
+
+// ExpiredRole describes a temporary role that was taken back.
+type ExpiredRole struct {
+	User     models.User
+	Expired  *models.Role
+	Restored *models.Role
+}
+
+// RevokeExpiredRoles restores the previous role of every user whose
+// temporary role has expired. A grant that would leave nobody able to manage
+// rights is made permanent instead.
+func (s *UserService) RevokeExpiredRoles(ctx context.Context) ([]ExpiredRole, error) {
+	s.rightsMu.Lock()
+	defer s.rightsMu.Unlock()
+
+	users, err := s.db.GetUsersWithExpiredRoles(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []ExpiredRole
+	for _, user := range users {
+		if err := s.loadRole(&user); err != nil {
+			return revoked, err
+		}
+		expired := user.Role
+		if expired == nil {
+			expired = &models.Role{ID: user.RoleID, Name: models.BuiltInRoleForRights(user.Rights)}
+		}
+
+		restored, err := s.db.GetRoleByID(user.PreviousRoleID)
+		if err == models.ErrRoleNotFound {
+			restored, err = s.db.GetRoleByName(models.RoleUser)
+		}
+		if err != nil {
+			return revoked, err
+		}
+
+		if _, err := s.checkRoleChange(user.TelegramID, restored); err == models.ErrLastAdmin {
+			slog.WarnContext(ctx, "Keeping expired role: nobody else can manage rights",
+				"role", expired.Name, "target_id", user.TelegramID)
+			restored = expired
+		} else if err != nil {
+			return revoked, err
+		}
+
+		if err := s.db.UpdateUserRights(user.TelegramID, restored); err != nil {
+			return revoked, err
+		}
+		if restored.ID != expired.ID {
+			revoked = append(revoked, ExpiredRole{User: user, Expired: expired, Restored: restored})
+		}
+	}
+	return revoked, nil
+}
+
+func (s *UserService) GetUserByTelegramID(telegramID int64) (*models.User, error) {
+	user, err := s.db.GetUserByTelegramID(telegramID)
+	if err != nil {
+		return nil, err
+	}
+	return user, s.loadRole(user)
+}
+
+// GetUsers returns one page of the user directory, most recently active
+// first, along with the total number of pages.
+func (s *UserService) GetUsers(filter models.UserFilter, page int) ([]models.User, int, error) {
+	total, err := s.db.CountUsers(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	totalPages := (total + s.usersPerPage - 1) / s.usersPerPage
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	users, err := s.db.GetUsers(filter, s.usersPerPage, (page-1)*s.usersPerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, totalPages, nil
+}
+
+// GrantRole gives the role to the user, creating the user if they have never
+// written to the bot. It returns the user as they were before the change, or
+// nil if the user was created.
+func (s *UserService) GrantRole(ctx context.Context, telegramID int64, role *models.Role) (*models.User, error) {
+	previous, err := s.GetUserByTelegramID(telegramID)
+	if err == models.ErrUserNotFound {
+		previous = nil
+		user := &models.User{
+			TelegramID: telegramID,
+			Rights:     role.Level(),
+		}
+		if err := s.db.CreateUser(user); err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "Created user to grant a role", "target_id", telegramID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return previous, s.UpdateUserRights(ctx, telegramID, role)
+}
+
+func (s *UserService) GetUsersWithRole(roleID int64) ([]models.User, error) {
+	return s.db.GetUsersWithRole(roleID)
+}
+
+func (s *UserService) UpdateUserMembership(ctx context.Context, telegramID int64, membership models.Membership) error {
+	if err := s.db.UpdateUserMembership(telegramID, membership); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Membership changed", "target_id", telegramID, "membership", membership)
+	return nil
+}
+
+// BanUser blocks the user from the bot until the given time, or for good if
+// it is zero. A ban replaces any mute. The last user who can manage rights
+// cannot be banned.
+func (s *UserService) BanUser(ctx context.Context, telegramID int64, reason string, until time.Time) error {
+	s.rightsMu.Lock()
+	defer s.rightsMu.Unlock()
+
+	user, err := s.GetUserByTelegramID(telegramID)
+	if err != nil {
+		return err
+	}
+	// A banned user cannot manage rights, so banning works like a demotion.
+	if user.HasPermission(models.PermRightsManage) && !user.Banned {
+		admins, err := s.db.CountUsersWithPermission(models.PermRightsManage, 0)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			slog.WarnContext(ctx, "Refusing to ban the last user who can manage rights", "target_id", telegramID)
+			return models.ErrLastAdmin
+		}
+	}
+	return s.restrict(ctx, telegramID, true, false, reason, until)
+}
+
+// MuteUser keeps the user from submitting ideas until the given time, or for
+// good if it is zero.
+func (s *UserService) MuteUser(ctx context.Context, telegramID int64, reason string, until time.Time) error {
+	return s.restrict(ctx, telegramID, false, true, reason, until)
+}
+
+func (s *UserService) LiftRestriction(ctx context.Context, telegramID int64) error {
+	return s.restrict(ctx, telegramID, false, false, "", time.Time{})
+}
+
+func (s *UserService) restrict(ctx context.Context, telegramID int64, banned, muted bool, reason string, until time.Time) error {
+	if err := s.db.UpdateUserRestriction(telegramID, banned, muted, reason, until); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Restriction changed", "target_id", telegramID, "banned", banned, "muted", muted, "until", until)
+	return nil
+}
+
+// LiftExpiredRestrictions lifts every ban and mute that has run out and
+// returns the affected users as they were before.
+func (s *UserService) LiftExpiredRestrictions(ctx context.Context) ([]models.User, error) {
+	users, err := s.db.GetUsersWithExpiredRestrictions(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var lifted []models.User
+	for _, user := range users {
+		if err := s.LiftRestriction(ctx, user.TelegramID); err != nil {
+			return lifted, err
+		}
+		lifted = append(lifted, user)
+	}
+	return lifted, nil
 }
 
 func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
@@ -68,6 +369,18 @@ func (s *UserService) GetAllAdmins() ([]models.User, error) {
 	return s.db.GetAllAdmins()
 }
 
+func (s *UserService) GetUsersWithPermission(permission models.Permission) ([]models.User, error) {
+	return s.db.GetUsersWithPermission(permission)
+}
+
 func (s *UserService) UpdateUserLanguage(telegramID int64, language string) error {
 	return s.db.UpdateUserLanguage(telegramID, language)
 }
+
+func (s *UserService) UpdateIdeaAlerts(telegramID int64, mode models.IdeaAlertMode) error {
+	return s.db.UpdateIdeaAlerts(telegramID, mode)
+}
+
+func (s *UserService) GetUsersWithIdeaAlerts(mode models.IdeaAlertMode) ([]models.User, error) {
+	return s.db.GetUsersWithIdeaAlerts(mode)
+}
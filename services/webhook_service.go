@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"lunobot/database"
+	"lunobot/models"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxWebhookAttempts = 5
+	webhookRetryDelay  = 5 * time.Second
+	webhookTimeout     = 10 * time.Second
+	// maxWebhookBacklog is how many deliveries may be in progress before the
+	// bot reports itself as not ready.
+	maxWebhookBacklog = 100
+)
+
+// WebhookEndpoint is a URL that receives the listed events, or every event
+// if Events is empty, signed with Secret.
+type WebhookEndpoint struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (e *WebhookEndpoint) wants(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, wanted := range e.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookService posts events to the configured endpoints. Each request is
+// signed like incoming device events: the X-Lunobot-Signature header holds
+// sha256=HMAC-SHA256(secret, "<X-Lunobot-Timestamp>.<body>") in hex.
+type WebhookService struct {
+	db             *database.DB
+	endpoints      []WebhookEndpoint
+	client         *http.Client
+	retryDelay     time.Duration
+	entriesPerPage int
+	inFlight       atomic.Int64
+	// stopMu orders starting a delivery against Shutdown, so that none is
+	// added to deliveries once Shutdown waits for them.
+	stopMu     sync.Mutex
+	stopping   bool
+	stop       chan struct{}
+	deliveries sync.WaitGroup
+}
+
+func NewWebhookService(db *database.DB, endpoints []WebhookEndpoint, entriesPerPage int) *WebhookService {
+	return &WebhookService{
+		db:             db,
+		endpoints:      endpoints,
+		client:         &http.Client{Timeout: webhookTimeout},
+		retryDelay:     webhookRetryDelay,
+		entriesPerPage: entriesPerPage,
+		stop:           make(chan struct{}),
+	}
+}
+
+type webhookPayload struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// Emit sends the event to every endpoint that wants it. Delivery happens in
+// the background and is retried with backoff; each one is recorded in the
+// delivery log. After Shutdown, deliveries are only recorded, to be sent by
+// ResumePending on the next start.
+func (s *WebhookService) Emit(ctx context.Context, event string, data interface{}) {
+	if len(s.endpoints) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(webhookPayload{Event: event, Time: time.Now(), Data: data})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding webhook payload", "event", event, "error", err)
+		return
+	}
+
+	for _, endpoint := range s.endpoints {
+		if !endpoint.wants(event) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			URL:     endpoint.URL,
+			Event:   event,
+			Payload: string(body),
+			Status:  models.WebhookDeliveryPending,
+		}
+		if err := s.db.CreateWebhookDelivery(delivery); err != nil {
+			slog.ErrorContext(ctx, "Error recording webhook delivery", "url", endpoint.URL, "error", err)
+			continue
+		}
+		s.startDelivery(ctx, endpoint, delivery)
+	}
+}
+
+// ResumePending goes on with the deliveries left pending when the bot last
+// stopped. Those for endpoints no longer configured are marked failed.
+func (s *WebhookService) ResumePending(ctx context.Context) error {
+	pending, err := s.db.GetPendingWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+
+	resumed := 0
+	for i := range pending {
+		delivery := &pending[i]
+		index := slices.IndexFunc(s.endpoints, func(e WebhookEndpoint) bool { return e.URL == delivery.URL })
+		if index < 0 {
+			delivery.Status = models.WebhookDeliveryFailed
+			delivery.Error = "endpoint is no longer configured"
+			if err := s.db.UpdateWebhookDelivery(delivery); err != nil {
+				return err
+			}
+			continue
+		}
+		s.startDelivery(ctx, s.endpoints[index], delivery)
+		resumed++
+	}
+	if resumed > 0 {
+		slog.InfoContext(ctx, "Resumed pending webhook deliveries", "count", resumed)
+	}
+	return nil
+}
+
+// Shutdown stops retrying and waits for the attempts in progress, or for ctx
+// to be done. Deliveries that still need a retry stay pending.
+func (s *WebhookService) Shutdown(ctx context.Context) error {
+	s.stopMu.Lock()
+	if !s.stopping {
+		s.stopping = true
+		close(s.stop)
+	}
+	s.stopMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.deliveries.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *WebhookService) startDelivery(ctx context.Context, endpoint WebhookEndpoint, delivery *models.WebhookDelivery) {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	if s.stopping {
+		return
+	}
+	s.inFlight.Add(1)
+	s.deliveries.Add(1)
+	// The delivery outlives the update or request that emitted it.
+	go s.deliver(context.WithoutCancel(ctx), endpoint, delivery, []byte(delivery.Payload))
+}
+
+// deliver tries to post the delivery until it succeeds, the endpoint rejects
+// it or the attempts run out, waiting twice as long after each failure.
+func (s *WebhookService) deliver(ctx context.Context, endpoint WebhookEndpoint, delivery *models.WebhookDelivery, body []byte) {
+	defer s.deliveries.Done()
+	defer s.inFlight.Add(-1)
+	delay := s.retryDelay
+	for {
+		delivery.Attempts++
+		code, err := s.post(ctx, endpoint, delivery, body)
+		delivery.ResponseCode = code
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		retry := err != nil && (code == 0 || code == http.StatusTooManyRequests || code >= 500)
+		switch {
+		case err == nil:
+			delivery.Status = models.WebhookDeliveryDelivered
+		case !retry || delivery.Attempts >= maxWebhookAttempts:
+			delivery.Status = models.WebhookDeliveryFailed
+			slog.WarnContext(ctx, "Webhook delivery failed", "event", delivery.Event, "url", delivery.URL,
+				"delivery_id", delivery.ID, "attempts", delivery.Attempts, "error", err)
+		}
+		if err := s.db.UpdateWebhookDelivery(delivery); err != nil {
+			slog.ErrorContext(ctx, "Error updating webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+		if delivery.Status != models.WebhookDeliveryPending {
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			slog.InfoContext(ctx, "Webhook delivery postponed until the next start", "delivery_id", delivery.ID)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// CheckBacklog reports how many deliveries are still being attempted and
+// fails once they pile up beyond maxWebhookBacklog.
+func (s *WebhookService) CheckBacklog() (map[string]interface{}, error) {
+	pending := s.inFlight.Load()
+	details := map[string]interface{}{"pending": pending}
+	if pending > maxWebhookBacklog {
+		return details, fmt.Errorf("%d webhook deliveries pending", pending)
+	}
+	return details, nil
+}
+
+// post sends one attempt and returns the response code, with an error for
+// anything but a 2xx response.
+func (s *WebhookService) post(ctx context.Context, endpoint WebhookEndpoint, delivery *models.WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(endpoint.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lunobot")
+	req.Header.Set("X-Lunobot-Event", delivery.Event)
+	req.Header.Set("X-Lunobot-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Lunobot-Timestamp", timestamp)
+	req.Header.Set("X-Lunobot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// GetDeliveries returns one page of the delivery log, newest first, along
+// with the total number of pages.
+func (s *WebhookService) GetDeliveries(page int) ([]models.WebhookDelivery, int, error) {
+	total, err := s.db.CountWebhookDeliveries()
+	if err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	totalPages := (total + s.entriesPerPage - 1) / s.entriesPerPage
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	deliveries, err := s.db.GetWebhookDeliveries(s.entriesPerPage, (page-1)*s.entriesPerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deliveries, totalPages, nil
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"lunobot/database"
+	"lunobot/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+func newTestWebhookService(t *testing.T, handler http.HandlerFunc) (*WebhookService, *database.DB) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	receiver := httptest.NewServer(handler)
+	t.Cleanup(receiver.Close)
+
+	s := NewWebhookService(db, []WebhookEndpoint{{URL: receiver.URL, Secret: testWebhookSecret}}, 5)
+	s.retryDelay = time.Millisecond
+	return s, db
+}
+
+// emitAndWait emits one event and returns its delivery once every attempt
+// has been made.
+func emitAndWait(t *testing.T, s *WebhookService, db *database.DB) models.WebhookDelivery {
+	t.Helper()
+	s.Emit(context.Background(), models.WebhookStatusChanged, map[string]bool{"is_open": true})
+	s.deliveries.Wait()
+
+	deliveries, err := db.GetWebhookDeliveries(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	s, db := newTestWebhookService(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-Lunobot-Timestamp")
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sent, 0)).Abs() > time.Minute {
+			t.Errorf("bad timestamp %q", timestamp)
+		}
+
+		mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if got, want := r.Header.Get("X-Lunobot-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		if got := r.Header.Get("X-Lunobot-Event"); got != models.WebhookStatusChanged {
+			t.Errorf("event header = %q", got)
+		}
+
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.Event != models.WebhookStatusChanged {
+			t.Errorf("bad payload %s: %v", body, err)
+		}
+	})
+
+	delivery := emitAndWait(t, s, db)
+	if delivery.Status != models.WebhookDeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK {
+		t.Errorf("delivery = %s after %d attempts with %d, want delivered after 1 with 200",
+			delivery.Status, delivery.Attempts, delivery.ResponseCode)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	for _, code := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(strconv.Itoa(code), func(t *testing.T) {
+			var requests atomic.Int32
+			var db *database.DB
+			var s *WebhookService
+			s, db = newTestWebhookService(t, func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					w.WriteHeader(code)
+					return
+				}
+				// The failed attempt is logged before the retry.
+				deliveries, err := db.GetWebhookDeliveries(10, 0)
+				if err != nil || len(deliveries) != 1 {
+					t.Errorf("got %d deliveries: %v", len(deliveries), err)
+					return
+				}
+				if d := deliveries[0]; d.Status != models.WebhookDeliveryPending || d.Attempts != 1 || d.ResponseCode != code {
+					t.Errorf("before retry: %s after %d attempts with %d, want pending after 1 with %d",
+						d.Status, d.Attempts, d.ResponseCode, code)
+				}
+			})
+
+			delivery := emitAndWait(t, s, db)
+			if requests.Load() != 2 {
+				t.Errorf("got %d requests, want 2", requests.Load())
+			}
+			if delivery.Status != models.WebhookDeliveryDelivered || delivery.Attempts != 2 || delivery.Error != "" {
+				t.Errorf("delivery = %s after %d attempts (%q), want delivered after 2",
+					delivery.Status, delivery.Attempts, delivery.Error)
+			}
+		})
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	var requests atomic.Int32
+	s, db := newTestWebhookService(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	delivery := emitAndWait(t, s, db)
+	if requests.Load() != maxWebhookAttempts {
+		t.Errorf("got %d requests, want %d", requests.Load(), maxWebhookAttempts)
+	}
+	if delivery.Status != models.WebhookDeliveryFailed || delivery.Attempts != maxWebhookAttempts || delivery.ResponseCode != http.StatusBadGateway {
+		t.Errorf("delivery = %s after %d attempts with %d, want failed after %d with 502",
+			delivery.Status, delivery.Attempts, delivery.ResponseCode, maxWebhookAttempts)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusGone} {
+		t.Run(strconv.Itoa(code), func(t *testing.T) {
+			var requests atomic.Int32
+			s, db := newTestWebhookService(t, func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(code)
+			})
+
+			delivery := emitAndWait(t, s, db)
+			if requests.Load() != 1 {
+				t.Errorf("got %d requests, want 1", requests.Load())
+			}
+			if delivery.Status != models.WebhookDeliveryFailed || delivery.Attempts != 1 || delivery.ResponseCode != code || delivery.Error == "" {
+				t.Errorf("delivery = %s after %d attempts with %d (%q), want failed after 1 with %d",
+					delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error, code)
+			}
+		})
+	}
+}